@@ -0,0 +1,132 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/mds"
+)
+
+// ErrRejected is returned when a new credential does not satisfy the attestation policy.
+var ErrRejected = errors.New("attestation rejected by policy")
+
+// Policy decides which authenticators can be registered. Its zero value accepts all of them.
+//
+// The AAGUID is only vouched for by the attestation certificate, so allow and block lists are
+// only reliable together with Roots. Without them an authenticator can claim any model.
+type Policy struct {
+	Conveyance protocol.ConveyancePreference
+	// If set, the attestation certificate must chain up to one of these
+	Roots          *x509.CertPool
+	AllowedAAGUIDs []uuid.UUID
+	BlockedAAGUIDs []uuid.UUID
+	// Authenticator models with a compromised status in the metadata are rejected
+	Metadata *mds.Service
+}
+
+func NewPolicy(cfg config.AttestationConfig, metadata *mds.Service) (Policy, error) {
+	policy := Policy{
+		Conveyance: protocol.ConveyancePreference(cfg.Conveyance),
+		Metadata:   metadata,
+	}
+
+	if cfg.RootCertificates != "" {
+		pem, err := os.ReadFile(cfg.RootCertificates)
+		if err != nil {
+			return Policy{}, err
+		}
+		policy.Roots = x509.NewCertPool()
+		if !policy.Roots.AppendCertsFromPEM(pem) {
+			return Policy{}, fmt.Errorf("no certificates found in %s", cfg.RootCertificates)
+		}
+	}
+
+	var err error
+	if policy.AllowedAAGUIDs, err = parseAAGUIDs(cfg.AllowedAAGUIDs); err != nil {
+		return Policy{}, err
+	}
+	if policy.BlockedAAGUIDs, err = parseAAGUIDs(cfg.BlockedAAGUIDs); err != nil {
+		return Policy{}, err
+	}
+
+	return policy, nil
+}
+
+func parseAAGUIDs(values []string) ([]uuid.UUID, error) {
+	aaguids := make([]uuid.UUID, len(values))
+	for i, value := range values {
+		aaguid, err := uuid.Parse(value)
+		if err != nil {
+			return nil, err
+		}
+		aaguids[i] = aaguid
+	}
+	return aaguids, nil
+}
+
+// Verify checks the attestation of a credential that the WebAuthn library already verified
+// cryptographically. Errors wrap ErrRejected.
+func (p Policy) Verify(attestationObject protocol.AttestationObject) error {
+	aaguid, err := uuid.FromBytes(attestationObject.AuthData.AttData.AAGUID)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+
+	if slices.Contains(p.BlockedAAGUIDs, aaguid) {
+		return fmt.Errorf("%w: authenticator %s is blocked", ErrRejected, aaguid)
+	}
+	if len(p.AllowedAAGUIDs) > 0 && !slices.Contains(p.AllowedAAGUIDs, aaguid) {
+		return fmt.Errorf("%w: authenticator %s is not allowed", ErrRejected, aaguid)
+	}
+	if entry, ok := p.Metadata.Lookup(aaguid); ok && entry.Compromised() {
+		return fmt.Errorf("%w: authenticator %s has status %s", ErrRejected, aaguid, entry.Status())
+	}
+
+	if p.Roots != nil {
+		if err := p.verifyChain(attestationObject); err != nil {
+			return fmt.Errorf("%w: %v", ErrRejected, err)
+		}
+	}
+
+	return nil
+}
+
+// verifyChain checks that the x5c attestation certificate chains up to a trusted root. Formats
+// without x5c, like none, self attestation and android-safetynet, never pass.
+func (p Policy) verifyChain(attestationObject protocol.AttestationObject) error {
+	x5c, ok := attestationObject.AttStatement["x5c"].([]interface{})
+	if !ok || len(x5c) == 0 {
+		return fmt.Errorf("%s attestation has no certificate", attestationObject.Format)
+	}
+
+	certificates := make([]*x509.Certificate, len(x5c))
+	for i, c := range x5c {
+		der, ok := c.([]byte)
+		if !ok {
+			return errors.New("invalid certificate in x5c")
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		certificates[i] = certificate
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+
+	_, err := certificates[0].Verify(x509.VerifyOptions{
+		Roots:         p.Roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
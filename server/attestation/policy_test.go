@@ -0,0 +1,83 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var yubiKey = uuid.MustParse("fa2b99dc-9e39-4257-8f92-4a30d23c4118")
+
+func attestationObject(aaguid uuid.UUID, chain ...*x509.Certificate) protocol.AttestationObject {
+	attestationObject := protocol.AttestationObject{Format: "none"}
+	attestationObject.AuthData.AttData.AAGUID = aaguid[:]
+	if len(chain) > 0 {
+		x5c := make([]interface{}, len(chain))
+		for i, certificate := range chain {
+			x5c[i] = certificate.Raw
+		}
+		attestationObject.Format = "packed"
+		attestationObject.AttStatement = map[string]interface{}{"x5c": x5c}
+	}
+	return attestationObject
+}
+
+func TestPolicy_Verify(t *testing.T) {
+	root, rootKey := testutil.NewCertificate(t, "Root", nil, nil)
+	leaf, _ := testutil.NewCertificate(t, "Authenticator", root, rootKey)
+	otherRoot, otherRootKey := testutil.NewCertificate(t, "Other root", nil, nil)
+	otherLeaf, _ := testutil.NewCertificate(t, "Other authenticator", otherRoot, otherRootKey)
+
+	t.Run("zero policy accepts everything", func(t *testing.T) {
+		assert.NoError(t, Policy{}.Verify(attestationObject(uuid.Nil)))
+	})
+
+	t.Run("blocked AAGUID", func(t *testing.T) {
+		policy := Policy{BlockedAAGUIDs: []uuid.UUID{yubiKey}}
+		assert.ErrorIs(t, policy.Verify(attestationObject(yubiKey)), ErrRejected)
+		assert.NoError(t, policy.Verify(attestationObject(uuid.Nil)))
+	})
+
+	t.Run("allowed AAGUIDs", func(t *testing.T) {
+		policy := Policy{AllowedAAGUIDs: []uuid.UUID{yubiKey}}
+		assert.NoError(t, policy.Verify(attestationObject(yubiKey)))
+		assert.ErrorIs(t, policy.Verify(attestationObject(uuid.Nil)), ErrRejected)
+	})
+
+	t.Run("trusted roots", func(t *testing.T) {
+		roots := x509.NewCertPool()
+		roots.AddCert(root)
+		policy := Policy{Roots: roots}
+
+		assert.NoError(t, policy.Verify(attestationObject(yubiKey, leaf)))
+		assert.ErrorIs(t, policy.Verify(attestationObject(yubiKey, otherLeaf)), ErrRejected)
+		assert.ErrorIs(t, policy.Verify(attestationObject(yubiKey)), ErrRejected)
+	})
+}
+
+func TestNewPolicy(t *testing.T) {
+	root, _ := testutil.NewCertificate(t, "Root", nil, nil)
+	file := filepath.Join(t.TempDir(), "roots.pem")
+	assert.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0o600))
+
+	policy, err := NewPolicy(config.AttestationConfig{
+		Conveyance:       "direct",
+		RootCertificates: file,
+		AllowedAAGUIDs:   []string{yubiKey.String()},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PreferDirectAttestation, policy.Conveyance)
+	assert.NotNil(t, policy.Roots)
+	assert.Equal(t, []uuid.UUID{yubiKey}, policy.AllowedAAGUIDs)
+
+	_, err = NewPolicy(config.AttestationConfig{RootCertificates: filepath.Join(t.TempDir(), "missing.pem")}, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,411 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config holds all settings of the server. Values are read from, in increasing order of
+// precedence, the defaults below, an optional YAML file, environment variables and flags.
+// Every setting can be given as an environment variable (see the env tags) or as a flag
+// named after it, e.g. DB_HOST and -db-host.
+type Config struct {
+	Server      ServerConfig      `yaml:"server"`
+	Database    DatabaseConfig    `yaml:"database"`
+	Redis       RedisConfig       `yaml:"redis"`
+	Session     SessionConfig     `yaml:"session"`
+	WebAuthn    WebAuthnConfig    `yaml:"webauthn"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Admin       AdminConfig       `yaml:"admin"`
+	Auth        AuthConfig        `yaml:"auth"`
+	CSRF        CSRFConfig        `yaml:"csrf"`
+	Attestation AttestationConfig `yaml:"attestation"`
+	Metadata    MetadataConfig    `yaml:"metadata"`
+	Providers   ProvidersConfig   `yaml:"passkey_providers"`
+	Mail        MailConfig        `yaml:"mail"`
+}
+
+type ServerConfig struct {
+	Address string `yaml:"address" env:"SERVER_ADDRESS"`
+	// IP addresses or CIDR ranges of reverse proxies whose X-Forwarded-For header is trusted.
+	// Without any, the client IP is the remote address of the connection.
+	TrustedProxies []string `yaml:"trusted_proxies" env:"SERVER_TRUSTED_PROXIES"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host" env:"DB_HOST"`
+	Port     int    `yaml:"port" env:"DB_PORT"`
+	User     string `yaml:"user" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD"`
+	Name     string `yaml:"name" env:"DB_NAME"`
+	SSLMode  string `yaml:"ssl_mode" env:"DB_SSL_MODE"`
+}
+
+type RedisConfig struct {
+	Address  string `yaml:"address" env:"REDIS_ADDR"`
+	Password string `yaml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db" env:"REDIS_DB"`
+}
+
+type SessionConfig struct {
+	// Name of the cookie holding the authenticated session
+	Name   string `yaml:"name" env:"SESSION_NAME"`
+	Secret string `yaml:"secret" env:"SESSION_SECRET"`
+	// Backend for WebAuthn ceremony state: memory, redis or sql
+	Store string `yaml:"store" env:"SESSION_STORE"`
+	// Attributes of all cookies set by the server. A name starting with __Host- or __Secure-
+	// requires Secure, __Host- also forbids a domain. The ceremony cookies get the same prefix.
+	CookieSecure   bool   `yaml:"cookie_secure" env:"SESSION_COOKIE_SECURE"`
+	CookieSameSite string `yaml:"cookie_same_site" env:"SESSION_COOKIE_SAME_SITE"`
+	CookieDomain   string `yaml:"cookie_domain" env:"SESSION_COOKIE_DOMAIN"`
+	// Sessions end after this long without activity
+	IdleTimeout time.Duration `yaml:"idle_timeout" env:"SESSION_IDLE_TIMEOUT"`
+	// Sessions end this long after login regardless of activity
+	AbsoluteLifetime time.Duration `yaml:"absolute_lifetime" env:"SESSION_ABSOLUTE_LIFETIME"`
+	// Replaces AbsoluteLifetime when the user asked to be remembered
+	RememberMeLifetime time.Duration `yaml:"remember_me_lifetime" env:"SESSION_REMEMBER_ME_LIFETIME"`
+	// Replaces IdleTimeout when the user asked to be remembered
+	RememberMeIdleTimeout time.Duration `yaml:"remember_me_idle_timeout" env:"SESSION_REMEMBER_ME_IDLE_TIMEOUT"`
+	// Sensitive operations require a login or re-authentication within this window
+	ReauthWindow time.Duration `yaml:"reauth_window" env:"SESSION_REAUTH_WINDOW"`
+}
+
+type WebAuthnConfig struct {
+	RPDisplayName string `yaml:"rp_display_name" env:"RP_DISPLAY_NAME"`
+	RPID          string `yaml:"rp_id" env:"RP_ID"`
+	// Origins allowed to run ceremonies, e.g. https://example.com or android:apk-key-hash:<hash>.
+	// Web origins are also published as related origins under /.well-known/webauthn.
+	RPOrigins []string `yaml:"rp_origins" env:"RP_ORIGINS"`
+	// Origins of pages allowed to embed the ceremonies in a cross-origin iframe
+	RPTopOrigins []string `yaml:"rp_top_origins" env:"RP_TOP_ORIGINS"`
+}
+
+type RateLimitConfig struct {
+	// Backend for the counters: memory or redis
+	Store string `yaml:"store" env:"RATE_LIMIT_STORE"`
+	// Requests to login and registration endpoints allowed per Window from one IP,
+	// for one username and in total
+	Window      time.Duration `yaml:"window" env:"RATE_LIMIT_WINDOW"`
+	PerIP       int           `yaml:"per_ip" env:"RATE_LIMIT_PER_IP"`
+	PerUsername int           `yaml:"per_username" env:"RATE_LIMIT_PER_USERNAME"`
+	Global      int           `yaml:"global" env:"RATE_LIMIT_GLOBAL"`
+	// After LockoutThreshold failed password logins within LockoutWindow, the account is locked
+	// for LockoutDuration, doubling with every further failure up to MaxLockoutDuration
+	LockoutThreshold   int           `yaml:"lockout_threshold" env:"RATE_LIMIT_LOCKOUT_THRESHOLD"`
+	LockoutWindow      time.Duration `yaml:"lockout_window" env:"RATE_LIMIT_LOCKOUT_WINDOW"`
+	LockoutDuration    time.Duration `yaml:"lockout_duration" env:"RATE_LIMIT_LOCKOUT_DURATION"`
+	MaxLockoutDuration time.Duration `yaml:"max_lockout_duration" env:"RATE_LIMIT_MAX_LOCKOUT_DURATION"`
+}
+
+type AdminConfig struct {
+	// Bearer token for the /admin endpoints, which are disabled while it is empty
+	Token string `yaml:"token" env:"ADMIN_TOKEN"`
+}
+
+type AuthConfig struct {
+	// Hide whether a username exists: login and registration failures get the same response
+	// and take about the same time, while the actual reason is only logged
+	AntiEnumeration bool `yaml:"anti_enumeration" env:"AUTH_ANTI_ENUMERATION"`
+	// Argon2id parameters of new password hashes: memory in KiB, passes over the memory and
+	// parallel lanes. Stored hashes with less memory or fewer passes are replaced at the next login.
+	PasswordHashMemory      int `yaml:"password_hash_memory" env:"AUTH_PASSWORD_HASH_MEMORY"`
+	PasswordHashIterations  int `yaml:"password_hash_iterations" env:"AUTH_PASSWORD_HASH_ITERATIONS"`
+	PasswordHashParallelism int `yaml:"password_hash_parallelism" env:"AUTH_PASSWORD_HASH_PARALLELISM"`
+}
+
+type CSRFConfig struct {
+	// Paths, or path prefixes ending in /, where requests with a bearer token skip the origin
+	// check. Browsers cannot send those cross-site, so only API clients are affected.
+	ExemptPaths []string `yaml:"exempt_paths" env:"CSRF_EXEMPT_PATHS"`
+}
+
+type AttestationConfig struct {
+	// Attestation requested from authenticators on registration: none, indirect, direct or enterprise
+	Conveyance string `yaml:"conveyance" env:"ATTESTATION_CONVEYANCE"`
+	// PEM file of root certificates. If set, only authenticators whose attestation certificate
+	// chains up to one of them can be registered.
+	RootCertificates string `yaml:"root_certificates" env:"ATTESTATION_ROOT_CERTIFICATES"`
+	// AAGUIDs of authenticator models that can or cannot be registered. An empty allow list
+	// allows all models that are not blocked.
+	AllowedAAGUIDs []string `yaml:"allowed_aaguids" env:"ATTESTATION_ALLOWED_AAGUIDS"`
+	BlockedAAGUIDs []string `yaml:"blocked_aaguids" env:"ATTESTATION_BLOCKED_AAGUIDS"`
+}
+
+type MetadataConfig struct {
+	// FIDO Metadata Service (MDS3) blob, downloaded from https://mds3.fidoalliance.org.
+	// Metadata is not used while it is empty.
+	Blob string `yaml:"blob" env:"MDS_BLOB"`
+	// PEM file of the root certificate the blob is signed with, the FIDO Alliance root if empty
+	RootCertificate string `yaml:"root_certificate" env:"MDS_ROOT_CERTIFICATE"`
+	// The blob file is read again this often, so it can be replaced without a restart
+	RefreshInterval time.Duration `yaml:"refresh_interval" env:"MDS_REFRESH_INTERVAL"`
+}
+
+type ProvidersConfig struct {
+	// JSON file mapping AAGUIDs to passkey provider names and icons, in the format of
+	// https://github.com/passkeydeveloper/passkey-authenticator-aaguids. Its entries are
+	// added to, and take precedence over, the bundled ones.
+	File string `yaml:"file" env:"PASSKEY_PROVIDERS_FILE"`
+}
+
+type MailConfig struct {
+	// Delivery of emails: stdout, file or smtp. The first two only print or store the
+	// messages, so all flows can be tried without a mail service.
+	Backend string `yaml:"backend" env:"MAIL_BACKEND"`
+	// Sender address of all emails
+	From string `yaml:"from" env:"MAIL_FROM"`
+	// Directory the file backend writes every email to as an .eml file
+	OutboxDir    string `yaml:"outbox_dir" env:"MAIL_OUTBOX_DIR"`
+	SMTPHost     string `yaml:"smtp_host" env:"MAIL_SMTP_HOST"`
+	SMTPPort     int    `yaml:"smtp_port" env:"MAIL_SMTP_PORT"`
+	SMTPUsername string `yaml:"smtp_username" env:"MAIL_SMTP_USERNAME"`
+	SMTPPassword string `yaml:"smtp_password" env:"MAIL_SMTP_PASSWORD"`
+	// Origin the links in emails point to, the first web origin in webauthn.rp_origins if empty
+	LinkOrigin string `yaml:"link_origin" env:"MAIL_LINK_ORIGIN"`
+	// Links to verify an email address expire after this long
+	VerificationLinkLifetime time.Duration `yaml:"verification_link_lifetime" env:"MAIL_VERIFICATION_LINK_LIFETIME"`
+	// Links to reset a password expire after this long
+	PasswordResetLinkLifetime time.Duration `yaml:"password_reset_link_lifetime" env:"MAIL_PASSWORD_RESET_LINK_LIFETIME"`
+}
+
+// Default returns the configuration used for settings that are not set anywhere else.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Address: ":9044",
+		},
+		Database: DatabaseConfig{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "disable",
+		},
+		Redis: RedisConfig{
+			Address: "localhost:6379",
+		},
+		Session: SessionConfig{
+			Name:                  "auth",
+			Store:                 "memory",
+			CookieSameSite:        "lax",
+			IdleTimeout:           2 * time.Hour,
+			AbsoluteLifetime:      24 * time.Hour,
+			RememberMeLifetime:    30 * 24 * time.Hour,
+			RememberMeIdleTimeout: 7 * 24 * time.Hour,
+			ReauthWindow:          5 * time.Minute,
+		},
+		WebAuthn: WebAuthnConfig{
+			RPDisplayName: "PasskeyDemo",
+		},
+		RateLimit: RateLimitConfig{
+			Store:              "memory",
+			Window:             time.Minute,
+			PerIP:              30,
+			PerUsername:        10,
+			Global:             1000,
+			LockoutThreshold:   5,
+			LockoutWindow:      time.Hour,
+			LockoutDuration:    time.Minute,
+			MaxLockoutDuration: time.Hour,
+		},
+		Auth: AuthConfig{
+			// The second recommended option of RFC 9106
+			PasswordHashMemory:      64 * 1024,
+			PasswordHashIterations:  3,
+			PasswordHashParallelism: 4,
+		},
+		CSRF: CSRFConfig{
+			ExemptPaths: []string{"/admin/"},
+		},
+		Attestation: AttestationConfig{
+			Conveyance: "none",
+		},
+		Metadata: MetadataConfig{
+			RefreshInterval: time.Hour,
+		},
+		Mail: MailConfig{
+			Backend:                   "stdout",
+			From:                      "no-reply@localhost",
+			SMTPPort:                  587,
+			VerificationLinkLifetime:  24 * time.Hour,
+			PasswordResetLinkLifetime: 30 * time.Minute,
+		},
+	}
+}
+
+// Validate reports all invalid settings at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Address != "", "server.address (SERVER_ADDRESS) must be set")
+	for _, proxy := range c.Server.TrustedProxies {
+		check(parseIPRange(proxy) != nil, "server.trusted_proxies (SERVER_TRUSTED_PROXIES) must only contain IP addresses or CIDR ranges, got %q", proxy)
+	}
+
+	check(c.Database.Host != "", "database.host (DB_HOST) must be set")
+	check(c.Database.Port > 0 && c.Database.Port < 65536, "database.port (DB_PORT) must be a valid port, got %d", c.Database.Port)
+	check(c.Database.Name != "", "database.name (DB_NAME) must be set")
+
+	check(c.Session.Name != "", "session.name (SESSION_NAME) must be set")
+	check(c.Session.Secret != "", "session.secret (SESSION_SECRET) must be set")
+	check(!strings.HasPrefix(c.Session.Name, "__Secure-") || c.Session.CookieSecure, "session.name (SESSION_NAME) with the __Secure- prefix requires session.cookie_secure (SESSION_COOKIE_SECURE)")
+	check(!strings.HasPrefix(c.Session.Name, "__Host-") || c.Session.CookieSecure && c.Session.CookieDomain == "", "session.name (SESSION_NAME) with the __Host- prefix requires session.cookie_secure (SESSION_COOKIE_SECURE) and no session.cookie_domain (SESSION_COOKIE_DOMAIN)")
+	switch c.Session.CookieSameSite {
+	case "lax", "strict":
+	case "none":
+		check(c.Session.CookieSecure, "session.cookie_same_site (SESSION_COOKIE_SAME_SITE) none requires session.cookie_secure (SESSION_COOKIE_SECURE)")
+	default:
+		check(false, "session.cookie_same_site (SESSION_COOKIE_SAME_SITE) must be one of lax, strict or none, got %q", c.Session.CookieSameSite)
+	}
+	check(c.Session.IdleTimeout > 0, "session.idle_timeout (SESSION_IDLE_TIMEOUT) must be positive")
+	check(c.Session.AbsoluteLifetime >= c.Session.IdleTimeout, "session.absolute_lifetime (SESSION_ABSOLUTE_LIFETIME) must not be shorter than session.idle_timeout (SESSION_IDLE_TIMEOUT)")
+	check(c.Session.RememberMeLifetime >= c.Session.AbsoluteLifetime, "session.remember_me_lifetime (SESSION_REMEMBER_ME_LIFETIME) must not be shorter than session.absolute_lifetime (SESSION_ABSOLUTE_LIFETIME)")
+	check(c.Session.RememberMeIdleTimeout >= c.Session.IdleTimeout, "session.remember_me_idle_timeout (SESSION_REMEMBER_ME_IDLE_TIMEOUT) must not be shorter than session.idle_timeout (SESSION_IDLE_TIMEOUT)")
+	check(c.Session.ReauthWindow > 0, "session.reauth_window (SESSION_REAUTH_WINDOW) must be positive")
+	switch c.Session.Store {
+	case "memory", "sql":
+	case "redis":
+		check(c.Redis.Address != "", "redis.address (REDIS_ADDR) must be set when session.store is redis")
+	default:
+		check(false, "session.store (SESSION_STORE) must be one of memory, redis or sql, got %q", c.Session.Store)
+	}
+
+	check(c.WebAuthn.RPDisplayName != "", "webauthn.rp_display_name (RP_DISPLAY_NAME) must be set")
+	check(c.WebAuthn.RPID != "", "webauthn.rp_id (RP_ID) must be set")
+	check(len(c.WebAuthn.RPOrigins) > 0, "webauthn.rp_origins (RP_ORIGINS) must contain at least one origin")
+	for _, origin := range c.WebAuthn.RPOrigins {
+		check(strings.HasPrefix(origin, androidOriginPrefix) || isWebOrigin(origin), "webauthn.rp_origins (RP_ORIGINS) must only contain origins, got %q", origin)
+	}
+	for _, origin := range c.WebAuthn.RPTopOrigins {
+		check(isWebOrigin(origin), "webauthn.rp_top_origins (RP_TOP_ORIGINS) must only contain web origins, got %q", origin)
+	}
+
+	switch c.RateLimit.Store {
+	case "memory":
+	case "redis":
+		check(c.Redis.Address != "", "redis.address (REDIS_ADDR) must be set when rate_limit.store is redis")
+	default:
+		check(false, "rate_limit.store (RATE_LIMIT_STORE) must be one of memory or redis, got %q", c.RateLimit.Store)
+	}
+	check(c.RateLimit.Window > 0, "rate_limit.window (RATE_LIMIT_WINDOW) must be positive")
+	check(c.RateLimit.PerIP > 0, "rate_limit.per_ip (RATE_LIMIT_PER_IP) must be positive")
+	check(c.RateLimit.PerUsername > 0, "rate_limit.per_username (RATE_LIMIT_PER_USERNAME) must be positive")
+	check(c.RateLimit.Global > 0, "rate_limit.global (RATE_LIMIT_GLOBAL) must be positive")
+	check(c.RateLimit.LockoutThreshold > 0, "rate_limit.lockout_threshold (RATE_LIMIT_LOCKOUT_THRESHOLD) must be positive")
+	check(c.RateLimit.LockoutWindow > 0, "rate_limit.lockout_window (RATE_LIMIT_LOCKOUT_WINDOW) must be positive")
+	check(c.RateLimit.LockoutDuration > 0, "rate_limit.lockout_duration (RATE_LIMIT_LOCKOUT_DURATION) must be positive")
+	check(c.RateLimit.MaxLockoutDuration >= c.RateLimit.LockoutDuration, "rate_limit.max_lockout_duration (RATE_LIMIT_MAX_LOCKOUT_DURATION) must not be shorter than rate_limit.lockout_duration (RATE_LIMIT_LOCKOUT_DURATION)")
+
+	check(c.Auth.PasswordHashParallelism > 0 && c.Auth.PasswordHashParallelism < 256, "auth.password_hash_parallelism (AUTH_PASSWORD_HASH_PARALLELISM) must be between 1 and 255, got %d", c.Auth.PasswordHashParallelism)
+	check(c.Auth.PasswordHashMemory >= 8*c.Auth.PasswordHashParallelism, "auth.password_hash_memory (AUTH_PASSWORD_HASH_MEMORY) must be at least 8 KiB per lane of auth.password_hash_parallelism (AUTH_PASSWORD_HASH_PARALLELISM)")
+	check(c.Auth.PasswordHashIterations > 0, "auth.password_hash_iterations (AUTH_PASSWORD_HASH_ITERATIONS) must be positive")
+
+	for _, path := range c.CSRF.ExemptPaths {
+		check(strings.HasPrefix(path, "/"), "csrf.exempt_paths (CSRF_EXEMPT_PATHS) must only contain absolute paths, got %q", path)
+	}
+
+	switch c.Attestation.Conveyance {
+	case "none":
+		check(c.Attestation.RootCertificates == "", "attestation.root_certificates (ATTESTATION_ROOT_CERTIFICATES) requires attestation.conveyance (ATTESTATION_CONVEYANCE) other than none")
+	case "indirect", "direct", "enterprise":
+	default:
+		check(false, "attestation.conveyance (ATTESTATION_CONVEYANCE) must be one of none, indirect, direct or enterprise, got %q", c.Attestation.Conveyance)
+	}
+	for _, aaguid := range c.Attestation.AllowedAAGUIDs {
+		_, err := uuid.Parse(aaguid)
+		check(err == nil, "attestation.allowed_aaguids (ATTESTATION_ALLOWED_AAGUIDS) must only contain AAGUIDs, got %q", aaguid)
+	}
+	for _, aaguid := range c.Attestation.BlockedAAGUIDs {
+		_, err := uuid.Parse(aaguid)
+		check(err == nil, "attestation.blocked_aaguids (ATTESTATION_BLOCKED_AAGUIDS) must only contain AAGUIDs, got %q", aaguid)
+	}
+
+	check(c.Metadata.RefreshInterval > 0, "metadata.refresh_interval (MDS_REFRESH_INTERVAL) must be positive")
+
+	switch c.Mail.Backend {
+	case "stdout":
+	case "file":
+		check(c.Mail.OutboxDir != "", "mail.outbox_dir (MAIL_OUTBOX_DIR) must be set when mail.backend is file")
+	case "smtp":
+		check(c.Mail.SMTPHost != "", "mail.smtp_host (MAIL_SMTP_HOST) must be set when mail.backend is smtp")
+		check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort < 65536, "mail.smtp_port (MAIL_SMTP_PORT) must be a valid port, got %d", c.Mail.SMTPPort)
+	default:
+		check(false, "mail.backend (MAIL_BACKEND) must be one of stdout, file or smtp, got %q", c.Mail.Backend)
+	}
+	_, err := mail.ParseAddress(c.Mail.From)
+	check(err == nil, "mail.from (MAIL_FROM) must be an email address, got %q", c.Mail.From)
+	if c.Mail.LinkOrigin != "" {
+		check(isWebOrigin(c.Mail.LinkOrigin), "mail.link_origin (MAIL_LINK_ORIGIN) must be a web origin, got %q", c.Mail.LinkOrigin)
+	} else {
+		check(len(c.WebAuthn.WebOrigins()) > 0, "mail.link_origin (MAIL_LINK_ORIGIN) must be set when webauthn.rp_origins (RP_ORIGINS) has no web origin")
+	}
+	check(c.Mail.VerificationLinkLifetime > 0, "mail.verification_link_lifetime (MAIL_VERIFICATION_LINK_LIFETIME) must be positive")
+	check(c.Mail.PasswordResetLinkLifetime > 0, "mail.password_reset_link_lifetime (MAIL_PASSWORD_RESET_LINK_LIFETIME) must be positive")
+
+	return errors.Join(errs...)
+}
+
+const androidOriginPrefix = "android:apk-key-hash:"
+
+// TrustedProxyRanges returns the trusted proxies as IP ranges, a single address being a range of one.
+func (c ServerConfig) TrustedProxyRanges() []*net.IPNet {
+	var ranges []*net.IPNet
+	for _, proxy := range c.TrustedProxies {
+		if ipRange := parseIPRange(proxy); ipRange != nil {
+			ranges = append(ranges, ipRange)
+		}
+	}
+
+	return ranges
+}
+
+// parseIPRange parses a CIDR range like 10.0.0.0/8 or a single address, returning nil if s is neither.
+func parseIPRange(s string) *net.IPNet {
+	if _, ipRange, err := net.ParseCIDR(s); err == nil {
+		return ipRange
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+}
+
+// WebOrigins returns the allowed origins that belong to websites rather than apps.
+func (c WebAuthnConfig) WebOrigins() []string {
+	var origins []string
+	for _, origin := range c.RPOrigins {
+		if isWebOrigin(origin) {
+			origins = append(origins, origin)
+		}
+	}
+
+	return origins
+}
+
+// Origin returns the origin that links in emails point to.
+func (c MailConfig) Origin(webAuthnConfig WebAuthnConfig) string {
+	if c.LinkOrigin != "" {
+		return c.LinkOrigin
+	}
+	return webAuthnConfig.WebOrigins()[0]
+}
+
+// isWebOrigin reports whether s is a scheme and host without path, e.g. https://example.com:8443.
+func isWebOrigin(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "" && u.RawQuery == ""
+}
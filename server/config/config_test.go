@@ -0,0 +1,175 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func validEnv(t *testing.T) {
+	t.Setenv("DB_NAME", "mydb")
+	t.Setenv("SESSION_SECRET", "secret")
+	t.Setenv("RP_ID", "localhost")
+	t.Setenv("RP_ORIGINS", "http://localhost:9044")
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("defaults and environment", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("DB_PORT", "15432")
+
+		cfg, err := Load(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, ":9044", cfg.Server.Address)
+		assert.Equal(t, 15432, cfg.Database.Port)
+		assert.Equal(t, "localhost", cfg.WebAuthn.RPID)
+		assert.Equal(t, "memory", cfg.Session.Store)
+	})
+
+	t.Run("file, environment and flags in increasing precedence", func(t *testing.T) {
+		validEnv(t)
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		assert.NoError(t, os.WriteFile(file, []byte("server:\n  address: \":1000\"\nsession:\n  name: from-file\n  store: sql\n"), 0o600))
+		t.Setenv("SESSION_NAME", "from-env")
+
+		cfg, err := Load([]string{"-config", file, "-session-name", "from-flag"})
+		assert.NoError(t, err)
+		assert.Equal(t, ":1000", cfg.Server.Address)
+		assert.Equal(t, "sql", cfg.Session.Store)
+		assert.Equal(t, "from-flag", cfg.Session.Name)
+	})
+
+	t.Run("list of origins", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("RP_ORIGINS", "https://example.com, https://staging.example.com,android:apk-key-hash:abc")
+
+		cfg, err := Load(nil)
+		assert.NoError(t, err)
+		assert.Len(t, cfg.WebAuthn.RPOrigins, 3)
+		assert.Equal(t, []string{"https://example.com", "https://staging.example.com"}, cfg.WebAuthn.WebOrigins())
+	})
+
+	t.Run("trusted proxies", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("SERVER_TRUSTED_PROXIES", "10.0.0.0/8,192.0.2.1,2001:db8::1")
+
+		cfg, err := Load(nil)
+		assert.NoError(t, err)
+		ranges := cfg.Server.TrustedProxyRanges()
+		assert.Len(t, ranges, 3)
+		assert.Equal(t, "192.0.2.1/32", ranges[1].String())
+		assert.Equal(t, "2001:db8::1/128", ranges[2].String())
+
+		t.Setenv("SERVER_TRUSTED_PROXIES", "proxy.internal")
+		_, err = Load(nil)
+		assert.ErrorContains(t, err, `server.trusted_proxies (SERVER_TRUSTED_PROXIES) must only contain IP addresses or CIDR ranges, got "proxy.internal"`)
+	})
+
+	t.Run("__Host- cookie requires secure cookies without domain", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("SESSION_NAME", "__Host-auth")
+		t.Setenv("SESSION_COOKIE_DOMAIN", "example.com")
+
+		_, err := Load(nil)
+		assert.ErrorContains(t, err, "session.name (SESSION_NAME) with the __Host- prefix requires")
+
+		t.Setenv("SESSION_COOKIE_SECURE", "true")
+		t.Setenv("SESSION_COOKIE_DOMAIN", "")
+		_, err = Load(nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("session lifetimes", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("SESSION_IDLE_TIMEOUT", "15m")
+		t.Setenv("SESSION_ABSOLUTE_LIFETIME", "10m")
+		t.Setenv("SESSION_REMEMBER_ME_IDLE_TIMEOUT", "10m")
+
+		_, err := Load(nil)
+		assert.ErrorContains(t, err, "session.absolute_lifetime (SESSION_ABSOLUTE_LIFETIME) must not be shorter than session.idle_timeout (SESSION_IDLE_TIMEOUT)")
+		assert.ErrorContains(t, err, "session.remember_me_idle_timeout (SESSION_REMEMBER_ME_IDLE_TIMEOUT) must not be shorter than session.idle_timeout (SESSION_IDLE_TIMEOUT)")
+
+		t.Setenv("SESSION_ABSOLUTE_LIFETIME", "12h")
+		t.Setenv("SESSION_REMEMBER_ME_IDLE_TIMEOUT", "72h")
+		cfg, err := Load(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 15*time.Minute, cfg.Session.IdleTimeout)
+		assert.Equal(t, 30*24*time.Hour, cfg.Session.RememberMeLifetime)
+		assert.Equal(t, 72*time.Hour, cfg.Session.RememberMeIdleTimeout)
+	})
+
+	t.Run("attestation policy", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("ATTESTATION_ROOT_CERTIFICATES", "roots.pem")
+		t.Setenv("ATTESTATION_BLOCKED_AAGUIDS", "fa2b99dc-9e39-4257-8f92-4a30d23c4118,yubikey")
+
+		_, err := Load(nil)
+		assert.ErrorContains(t, err, "attestation.root_certificates (ATTESTATION_ROOT_CERTIFICATES) requires attestation.conveyance (ATTESTATION_CONVEYANCE) other than none")
+		assert.ErrorContains(t, err, `attestation.blocked_aaguids (ATTESTATION_BLOCKED_AAGUIDS) must only contain AAGUIDs, got "yubikey"`)
+
+		t.Setenv("ATTESTATION_CONVEYANCE", "direct")
+		t.Setenv("ATTESTATION_BLOCKED_AAGUIDS", "fa2b99dc-9e39-4257-8f92-4a30d23c4118")
+		cfg, err := Load(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, "direct", cfg.Attestation.Conveyance)
+	})
+
+	t.Run("password hash parameters", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("AUTH_PASSWORD_HASH_PARALLELISM", "256")
+		t.Setenv("AUTH_PASSWORD_HASH_ITERATIONS", "0")
+
+		_, err := Load(nil)
+		assert.ErrorContains(t, err, "auth.password_hash_parallelism (AUTH_PASSWORD_HASH_PARALLELISM) must be between 1 and 255, got 256")
+		assert.ErrorContains(t, err, "auth.password_hash_iterations (AUTH_PASSWORD_HASH_ITERATIONS) must be positive")
+
+		t.Setenv("AUTH_PASSWORD_HASH_PARALLELISM", "1")
+		t.Setenv("AUTH_PASSWORD_HASH_ITERATIONS", "2")
+		t.Setenv("AUTH_PASSWORD_HASH_MEMORY", "19456")
+		cfg, err := Load(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 19456, cfg.Auth.PasswordHashMemory)
+	})
+
+	t.Run("mail delivery", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("MAIL_BACKEND", "smtp")
+		t.Setenv("MAIL_FROM", "PasskeyDemo")
+
+		_, err := Load(nil)
+		assert.ErrorContains(t, err, "mail.smtp_host (MAIL_SMTP_HOST) must be set when mail.backend is smtp")
+		assert.ErrorContains(t, err, `mail.from (MAIL_FROM) must be an email address, got "PasskeyDemo"`)
+
+		t.Setenv("MAIL_SMTP_HOST", "smtp.example.com")
+		t.Setenv("MAIL_FROM", "PasskeyDemo <no-reply@example.com>")
+		cfg, err := Load(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 587, cfg.Mail.SMTPPort)
+		assert.Equal(t, "http://localhost:9044", cfg.Mail.Origin(cfg.WebAuthn))
+
+		t.Setenv("RP_ORIGINS", "android:apk-key-hash:abc")
+		_, err = Load(nil)
+		assert.ErrorContains(t, err, "mail.link_origin (MAIL_LINK_ORIGIN) must be set when webauthn.rp_origins (RP_ORIGINS) has no web origin")
+	})
+
+	t.Run("invalid number", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("DB_PORT", "abc")
+
+		_, err := Load(nil)
+		assert.ErrorContains(t, err, "invalid value for DB_PORT")
+	})
+
+	t.Run("reports every invalid setting", func(t *testing.T) {
+		validEnv(t)
+		t.Setenv("RP_ORIGINS", "localhost")
+
+		_, err := Load([]string{"-session-store", "file", "-rp-id", ""})
+		assert.ErrorContains(t, err, "session.store (SESSION_STORE) must be one of memory, redis or sql")
+		assert.ErrorContains(t, err, "webauthn.rp_id (RP_ID) must be set")
+		assert.ErrorContains(t, err, `webauthn.rp_origins (RP_ORIGINS) must only contain origins, got "localhost"`)
+	})
+}
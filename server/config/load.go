@@ -0,0 +1,137 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from a .env file in the working directory (if present),
+// the YAML file given by -config or CONFIG_FILE (if any), the environment and the
+// command line arguments, and validates the result.
+func Load(args []string) (*Config, error) {
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load .env file: %w", err)
+	}
+
+	cfg := Default()
+	fields := collectFields(reflect.ValueOf(&cfg).Elem(), "")
+
+	// Flags are parsed first to find the config file, but applied last so they take precedence
+	flagSet := flag.NewFlagSet("passkey-demo", flag.ContinueOnError)
+	configFile := flagSet.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML configuration file")
+	flagValues := make(map[string]string)
+	for _, f := range fields {
+		flagSet.Func(f.flagName(), fmt.Sprintf("sets %s (env %s)", f.path, f.env), func(value string) error {
+			flagValues[f.flagName()] = value
+			return nil
+		})
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		data, err := os.ReadFile(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", *configFile, err)
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := os.LookupEnv(f.env); ok {
+			if err := f.set(value); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		if value, ok := flagValues[f.flagName()]; ok {
+			if err := f.set(value); err != nil {
+				return nil, fmt.Errorf("invalid value for -%s: %w", f.flagName(), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	return &cfg, nil
+}
+
+// field is a single setting that can be set from the environment or a flag.
+type field struct {
+	path  string
+	env   string
+	value reflect.Value
+}
+
+func (f field) flagName() string {
+	return strings.ToLower(strings.ReplaceAll(f.env, "_", "-"))
+}
+
+func (f field) set(value string) error {
+	switch v := f.value.Addr().Interface().(type) {
+	case *string:
+		*v = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*v = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*v = b
+	case *time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*v = d
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.value.Type())
+	}
+
+	return nil
+}
+
+func collectFields(v reflect.Value, prefix string) []field {
+	var fields []field
+	for i := 0; i < v.NumField(); i++ {
+		structField := v.Type().Field(i)
+		path := prefix + structField.Tag.Get("yaml")
+
+		if env := structField.Tag.Get("env"); env != "" {
+			fields = append(fields, field{path: path, env: env, value: v.Field(i)})
+		} else if structField.Type.Kind() == reflect.Struct {
+			fields = append(fields, collectFields(v.Field(i), path+".")...)
+		}
+	}
+
+	return fields
+}
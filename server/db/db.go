@@ -4,24 +4,29 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"runtime"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/shangsuru/passkey-demo/config"
 	"github.com/uptrace/bun"
 	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/dialect/sqlitedialect"
 	"github.com/uptrace/bun/migrate"
 )
 
-func GetDB() *bun.DB {
+func GetDB(cfg config.DatabaseConfig) *bun.DB {
 	dbString := fmt.Sprintf(
-		"host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		os.Getenv("DB_HOST"),
-		os.Getenv("DB_PORT"),
-		os.Getenv("DB_USER"),
-		os.Getenv("DB_PASSWORD"),
-		os.Getenv("DB_NAME"),
+		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+		cfg.Host,
+		cfg.Port,
+		cfg.User,
+		cfg.Password,
+		cfg.Name,
+		cfg.SSLMode,
 	)
 
 	db, err := sql.Open("postgres", dbString)
@@ -37,13 +42,15 @@ func GetTestDB() *bun.DB {
 	if err != nil {
 		panic(err)
 	}
+	// Every connection would get its own empty in-memory database
+	db.SetMaxOpenConns(1)
 
 	testDB := bun.NewDB(db, sqlitedialect.New())
 
 	// Run migrations
 	ctx := context.Background()
-	migrations := migrate.NewMigrations()
-	if err = migrations.DiscoverCaller(); err != nil {
+	migrations, err := sqliteMigrations()
+	if err != nil {
 		panic(err)
 	}
 	migrator := migrate.NewMigrator(testDB, migrations)
@@ -57,3 +64,33 @@ func GetTestDB() *bun.DB {
 
 	return testDB
 }
+
+// sqliteMigrations returns the migrations with their SQLite variants, *.up.sqlite.sql and
+// *.down.sqlite.sql, in place of the Postgres ones where SQLite lacks a statement.
+func sqliteMigrations() (*migrate.Migrations, error) {
+	_, file, _, _ := runtime.Caller(0)
+	fsys := os.DirFS(filepath.Join(filepath.Dir(file), "migration"))
+
+	discovered := migrate.NewMigrations()
+	if err := discovered.Discover(fsys); err != nil {
+		return nil, err
+	}
+
+	migrations := migrate.NewMigrations()
+	for _, migration := range discovered.Sorted() {
+		if variant := migration.String() + ".up.sqlite.sql"; fileExists(fsys, variant) {
+			migration.Up = migrate.NewSQLMigrationFunc(fsys, variant)
+		}
+		if variant := migration.String() + ".down.sqlite.sql"; fileExists(fsys, variant) {
+			migration.Down = migrate.NewSQLMigrationFunc(fsys, variant)
+		}
+		migrations.Add(migration)
+	}
+
+	return migrations, nil
+}
+
+func fileExists(fsys fs.FS, name string) bool {
+	_, err := fs.Stat(fsys, name)
+	return err == nil
+}
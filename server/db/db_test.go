@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/migrate"
+)
+
+func TestMakeUsersPasswordHashNullable(t *testing.T) {
+	ctx := context.Background()
+	sqldb, err := sql.Open("sqlite3", ":memory:")
+	assert.NoError(t, err)
+	sqldb.SetMaxOpenConns(1)
+	database := bun.NewDB(sqldb, sqlitedialect.New())
+	defer database.Close()
+
+	all, err := sqliteMigrations()
+	assert.NoError(t, err)
+	migrateBefore := func(name string) {
+		migrations := migrate.NewMigrations()
+		for _, migration := range all.Sorted() {
+			if migration.Name < name {
+				migrations.Add(migration)
+			}
+		}
+		migrator := migrate.NewMigrator(database, migrations)
+		assert.NoError(t, migrator.Init(ctx))
+		_, err := migrator.Migrate(ctx)
+		assert.NoError(t, err)
+	}
+
+	migrateBefore("20261018140000")
+	_, err = database.ExecContext(ctx, `INSERT INTO users (id, username, password_hash, created_at) VALUES
+		('00000000-0000-0000-0000-000000000001', 'password_user', 'password hash', '2026-10-01 10:00:00'),
+		('00000000-0000-0000-0000-000000000002', 'passkey_user', 'random password hash', '2026-10-01 10:00:00'),
+		('00000000-0000-0000-0000-000000000003', 'password_user_with_passkey', 'password hash', '2026-10-01 10:00:00')`)
+	assert.NoError(t, err)
+	// The passkey sign-up created its first passkey in the same transaction as the account, the
+	// password user added one later
+	_, err = database.ExecContext(ctx, `INSERT INTO webauthn_credentials (id, user_id, credential_id, public_key, attestation_type, flags, authenticator, created_at) VALUES
+		('00000000-0000-0000-0000-00000000000a', '00000000-0000-0000-0000-000000000002', 'a', 'key', 'none', '{}', '{}', '2026-10-01 10:00:00'),
+		('00000000-0000-0000-0000-00000000000b', '00000000-0000-0000-0000-000000000003', 'b', 'key', 'none', '{}', '{}', '2026-10-01 10:03:00')`)
+	assert.NoError(t, err)
+
+	migrateBefore("99999999999999")
+	hashes := map[string]*string{}
+	rows, err := database.QueryContext(ctx, "SELECT username, password_hash FROM users")
+	assert.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var username string
+		var hash *string
+		assert.NoError(t, rows.Scan(&username, &hash))
+		hashes[username] = hash
+	}
+	assert.NoError(t, rows.Err())
+
+	assert.Nil(t, hashes["passkey_user"])
+	for _, username := range []string{"password_user", "password_user_with_passkey"} {
+		if assert.NotNil(t, hashes[username], username) {
+			assert.Equal(t, "password hash", *hashes[username])
+		}
+	}
+}
@@ -0,0 +1,3 @@
+ALTER TABLE webauthn_credentials DROP COLUMN last_used_at;
+--bun:split
+ALTER TABLE webauthn_credentials DROP COLUMN name;
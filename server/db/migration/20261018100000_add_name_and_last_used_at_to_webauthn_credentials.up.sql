@@ -0,0 +1,3 @@
+ALTER TABLE webauthn_credentials ADD COLUMN name VARCHAR(255) NOT NULL DEFAULT 'Passkey';
+--bun:split
+ALTER TABLE webauthn_credentials ADD COLUMN last_used_at TIMESTAMP;
@@ -0,0 +1,7 @@
+CREATE TABLE account_deletions
+(
+    id         UUID         NOT NULL PRIMARY KEY,
+    user_id    UUID         NOT NULL,
+    method     VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
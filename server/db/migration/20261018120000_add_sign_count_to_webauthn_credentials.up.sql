@@ -0,0 +1,3 @@
+ALTER TABLE webauthn_credentials ADD COLUMN sign_count BIGINT NOT NULL DEFAULT 0;
+--bun:split
+UPDATE webauthn_credentials SET sign_count = CAST(authenticator ->> 'signCount' AS BIGINT);
@@ -0,0 +1,3 @@
+DROP INDEX users_username_key;
+--bun:split
+DROP TABLE pending_registrations;
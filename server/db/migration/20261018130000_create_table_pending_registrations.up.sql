@@ -0,0 +1,9 @@
+CREATE TABLE pending_registrations
+(
+    id         UUID         NOT NULL PRIMARY KEY,
+    username   VARCHAR(255) NOT NULL UNIQUE,
+    expires_at TIMESTAMP    NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+--bun:split
+CREATE UNIQUE INDEX users_username_key ON users (username);
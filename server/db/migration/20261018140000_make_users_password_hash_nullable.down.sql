@@ -0,0 +1,5 @@
+UPDATE users
+SET password_hash = ''
+WHERE password_hash IS NULL;
+--bun:split
+ALTER TABLE users ALTER COLUMN password_hash SET NOT NULL;
@@ -0,0 +1,18 @@
+CREATE TABLE users_old
+(
+    id            UUID PRIMARY KEY,
+    username      VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(255) NOT NULL,
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+--bun:split
+INSERT INTO users_old (id, username, password_hash, created_at, updated_at)
+SELECT id, username, COALESCE(password_hash, ''), created_at, updated_at
+FROM users;
+--bun:split
+DROP TABLE users;
+--bun:split
+ALTER TABLE users_old RENAME TO users;
+--bun:split
+CREATE UNIQUE INDEX users_username_key ON users (username);
@@ -0,0 +1,14 @@
+ALTER TABLE users ALTER COLUMN password_hash DROP NOT NULL;
+--bun:split
+-- Passkey sign-ups used to store the hash of a random password. Since usernames are reserved by
+-- pending registrations, such an account and its first passkey are created in one transaction,
+-- so both got the same CURRENT_TIMESTAMP. Password accounts may have passkeys too, but these were
+-- added in a later request, so they keep their hash. Passkey sign-ups from before cannot be told
+-- apart from password accounts and keep a random password nobody knows until it is removed.
+UPDATE users
+SET password_hash = NULL
+WHERE password_hash = ''
+   OR EXISTS (SELECT 1
+              FROM webauthn_credentials
+              WHERE webauthn_credentials.user_id = users.id
+                AND webauthn_credentials.created_at = users.created_at);
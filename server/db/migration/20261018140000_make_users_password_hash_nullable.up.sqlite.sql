@@ -0,0 +1,32 @@
+-- SQLite cannot drop a NOT NULL constraint, so the table is rebuilt instead of altered
+CREATE TABLE users_new
+(
+    id            UUID PRIMARY KEY,
+    username      VARCHAR(255) NOT NULL,
+    password_hash VARCHAR(255),
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+--bun:split
+INSERT INTO users_new (id, username, password_hash, created_at, updated_at)
+SELECT id, username, NULLIF(password_hash, ''), created_at, updated_at
+FROM users;
+--bun:split
+DROP TABLE users;
+--bun:split
+ALTER TABLE users_new RENAME TO users;
+--bun:split
+CREATE UNIQUE INDEX users_username_key ON users (username);
+--bun:split
+-- Passkey sign-ups used to store the hash of a random password. Since usernames are reserved by
+-- pending registrations, such an account and its first passkey are created in one transaction,
+-- so both got the same CURRENT_TIMESTAMP. Password accounts may have passkeys too, but these were
+-- added in a later request, so they keep their hash. Passkey sign-ups from before cannot be told
+-- apart from password accounts and keep a random password nobody knows until it is removed.
+UPDATE users
+SET password_hash = NULL
+WHERE password_hash = ''
+   OR EXISTS (SELECT 1
+              FROM webauthn_credentials
+              WHERE webauthn_credentials.user_id = users.id
+                AND webauthn_credentials.created_at = users.created_at);
@@ -0,0 +1 @@
+DROP TABLE webauthn_sessions;
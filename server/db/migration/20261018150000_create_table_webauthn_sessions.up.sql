@@ -0,0 +1,7 @@
+CREATE TABLE webauthn_sessions
+(
+    id         VARCHAR(255) NOT NULL PRIMARY KEY,
+    data       BYTEA        NOT NULL,
+    expires_at TIMESTAMP    NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1 @@
+DROP TABLE login_sessions;
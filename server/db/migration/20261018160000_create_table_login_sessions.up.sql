@@ -0,0 +1,12 @@
+CREATE TABLE login_sessions
+(
+    id           UUID         NOT NULL PRIMARY KEY,
+    user_id      UUID         NOT NULL,
+    ip           VARCHAR(255) NOT NULL,
+    user_agent   TEXT         NOT NULL,
+    auth_method  VARCHAR(255) NOT NULL,
+    created_at   TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+--bun:split
+CREATE INDEX login_sessions_user_id_idx ON login_sessions (user_id);
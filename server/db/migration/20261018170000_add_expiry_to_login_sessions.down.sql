@@ -0,0 +1,3 @@
+ALTER TABLE login_sessions DROP COLUMN remember_me;
+--bun:split
+ALTER TABLE login_sessions DROP COLUMN expires_at;
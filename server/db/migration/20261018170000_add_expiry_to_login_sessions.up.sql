@@ -0,0 +1,3 @@
+ALTER TABLE login_sessions ADD COLUMN expires_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+--bun:split
+ALTER TABLE login_sessions ADD COLUMN remember_me BOOLEAN NOT NULL DEFAULT FALSE;
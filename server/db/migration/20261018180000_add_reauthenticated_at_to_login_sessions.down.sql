@@ -0,0 +1 @@
+ALTER TABLE login_sessions DROP COLUMN reauthenticated_at;
@@ -0,0 +1 @@
+ALTER TABLE login_sessions ADD COLUMN reauthenticated_at TIMESTAMP;
@@ -0,0 +1,3 @@
+ALTER TABLE webauthn_credentials DROP COLUMN attestation_object;
+--bun:split
+ALTER TABLE webauthn_credentials DROP COLUMN aaguid;
@@ -0,0 +1,3 @@
+ALTER TABLE webauthn_credentials ADD COLUMN attestation_object BYTEA;
+--bun:split
+ALTER TABLE webauthn_credentials ADD COLUMN aaguid UUID;
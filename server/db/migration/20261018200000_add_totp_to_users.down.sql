@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN totp_last_counter;
+--bun:split
+ALTER TABLE users DROP COLUMN totp_enabled_at;
+--bun:split
+ALTER TABLE users DROP COLUMN totp_secret;
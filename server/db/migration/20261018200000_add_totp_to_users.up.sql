@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN totp_secret VARCHAR(255);
+--bun:split
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP;
+--bun:split
+ALTER TABLE users ADD COLUMN totp_last_counter BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE login_sessions DROP COLUMN second_factor_pending;
@@ -0,0 +1 @@
+ALTER TABLE login_sessions ADD COLUMN second_factor_pending BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,10 @@
+CREATE TABLE recovery_codes
+(
+    id         UUID         NOT NULL PRIMARY KEY,
+    user_id    UUID         NOT NULL,
+    code_hash  VARCHAR(255) NOT NULL,
+    used_at    TIMESTAMP,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+--bun:split
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes (user_id);
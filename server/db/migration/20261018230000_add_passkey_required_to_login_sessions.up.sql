@@ -0,0 +1 @@
+ALTER TABLE login_sessions ADD COLUMN passkey_required BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+DROP INDEX users_email_key;
+--bun:split
+ALTER TABLE users DROP COLUMN email_verified_at;
+--bun:split
+ALTER TABLE users DROP COLUMN email;
@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN email VARCHAR(255);
+--bun:split
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+--bun:split
+CREATE UNIQUE INDEX users_email_key ON users (email);
@@ -0,0 +1,12 @@
+CREATE TABLE password_reset_tokens
+(
+    id         UUID         NOT NULL PRIMARY KEY,
+    user_id    UUID         NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP    NOT NULL,
+    created_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+--bun:split
+CREATE UNIQUE INDEX password_reset_tokens_token_hash_key ON password_reset_tokens (token_hash);
+--bun:split
+CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
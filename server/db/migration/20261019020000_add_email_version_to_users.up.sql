@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN email_version BIGINT NOT NULL DEFAULT 0;
@@ -6,21 +6,22 @@ import (
 	"os"
 	"strings"
 
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 	"github.com/uptrace/bun/migrate"
 	"github.com/urfave/cli/v2"
 
+	"github.com/shangsuru/passkey-demo/config"
 	"github.com/shangsuru/passkey-demo/db"
 )
 
 func main() {
-	err := godotenv.Load(".env")
+	// Flags are left to the CLI below, so settings come from .env, the config file and the environment
+	cfg, err := config.Load(nil)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
 	}
 
-	db := db.GetDB()
+	db := db.GetDB(cfg.Database)
 
 	err = db.Ping()
 	if err != nil {
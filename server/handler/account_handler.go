@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+	"github.com/shangsuru/passkey-demo/repository"
+)
+
+const (
+	deletionMethodPasskey  = "passkey"
+	deletionMethodPassword = "password"
+)
+
+type AccountController struct {
+	UserRepository  repository.UserRepository
+	WebAuthnAPI     *webauthn.WebAuthn
+	WebAuthnSession WebAuthnSession
+	SessionManager  SessionManager
+	Limiter         ratelimit.Limiter
+}
+
+// BeginDeleteAccount starts a passkey assertion restricted to the signed-in user's credentials
+// that confirms the account deletion.
+func (handler AccountController) BeginDeleteAccount() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if len(user.WebauthnCredentials) == 0 {
+			return sendError(ctx, "There is no passkey associated with this account.", http.StatusBadRequest)
+		}
+
+		options, sessionData, err := handler.WebAuthnAPI.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.WebAuthnSession.Create(ctx, CeremonyDeleteAccount, sessionData); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return ctx.JSON(http.StatusOK, options)
+	}
+}
+
+// FinishDeleteAccount deletes the signed-in user's account after a successful passkey assertion.
+func (handler AccountController) FinishDeleteAccount() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		sessionData, err := handler.WebAuthnSession.Get(ctx, CeremonyDeleteAccount)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+
+		if !bytes.Equal(sessionData.UserID, userID[:]) {
+			return sendError(ctx, "Confirmation was started by another account.", http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), sessionData.UserID)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		credential, err := handler.WebAuthnAPI.FinishLogin(user, *sessionData, ctx.Request())
+		if err != nil {
+			return sendError(ctx, "Passkey confirmation failed.", http.StatusUnauthorized)
+		}
+
+		if !credential.Flags.UserPresent || !credential.Flags.UserVerified {
+			return sendError(ctx, "User not present or not verified.", http.StatusBadRequest)
+		}
+
+		if credential.Authenticator.CloneWarning {
+			return sendError(ctx, "Authenticator is cloned.", http.StatusBadRequest)
+		}
+
+		return handler.deleteAccount(ctx, user.ID, deletionMethodPasskey)
+	}
+}
+
+// DeleteAccountWithPassword deletes the signed-in user's account after confirming their password.
+func (handler AccountController) DeleteAccountWithPassword() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if !user.HasPassword() {
+			return sendError(ctx, "This account has no password.", http.StatusBadRequest)
+		}
+
+		if ok, err := confirmPassword(ctx, handler.Limiter, user, p.Password); !ok {
+			return err
+		}
+
+		return handler.deleteAccount(ctx, user.ID, deletionMethodPassword)
+	}
+}
+
+func (handler AccountController) deleteAccount(ctx echo.Context, userID uuid.UUID, method string) error {
+	if err := handler.UserRepository.DeleteUserAccount(ctx.Request().Context(), userID, method); err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+	ctx.Logger().Infof("deleted account %s confirmed by %s", userID, method)
+
+	if err := handler.SessionManager.Terminate(ctx); err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+
+	return sendOK(ctx)
+}
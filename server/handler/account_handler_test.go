@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountController_DeleteAccountWithPassword(t *testing.T) {
+	accountController := AccountController{UserRepository: userRepository, SessionManager: sessionManager, Limiter: limiter}
+	passwordHash, err := argon2id.CreateHash("password123", argon2id.DefaultParams)
+	assert.NoError(t, err)
+
+	deleteAccount := func(t *testing.T, user *model.User, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/api/account/delete/password", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(accountController.DeleteAccountWithPassword())(ctx))
+		return rec
+	}
+
+	t.Run("not logged in", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/api/account/delete/password", strings.NewReader(`{"password":"password123"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(accountController.DeleteAccountWithPassword())(ctx))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("incorrect password", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "delete_account_wrong_password", passwordHash, 1)
+
+		rec := deleteAccount(t, user, `{"password":"wrongPassword"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid password."}`, rec.Body.String())
+
+		_, err := userRepository.FindUserByUsername(context.Background(), user.Username)
+		assert.NoError(t, err)
+	})
+
+	t.Run("wrong passwords lock the account", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "delete_account_lockout", passwordHash, 1)
+
+		assert.Equal(t, http.StatusUnauthorized, deleteAccount(t, user, `{"password":"wrong1"}`).Code)
+		assert.Equal(t, http.StatusUnauthorized, deleteAccount(t, user, `{"password":"wrong2"}`).Code)
+		assert.Equal(t, http.StatusTooManyRequests, deleteAccount(t, user, `{"password":"wrong3"}`).Code)
+		assert.Equal(t, http.StatusTooManyRequests, deleteAccount(t, user, `{"password":"password123"}`).Code)
+
+		_, err := userRepository.FindUserByUsername(context.Background(), user.Username)
+		assert.NoError(t, err)
+	})
+
+	t.Run("account without password", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "delete_account_no_password", "", 1)
+
+		rec := deleteAccount(t, user, `{"password":"password123"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"This account has no password."}`, rec.Body.String())
+	})
+
+	t.Run("successful deletion", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "delete_account_user", passwordHash, 2)
+
+		rec := deleteAccount(t, user, `{"password":"password123"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"status": "ok", "errorMessage":""}`, rec.Body.String())
+
+		// Removes the user with all their passkeys
+		_, err := userRepository.FindUserByUsername(context.Background(), user.Username)
+		assert.Error(t, err)
+		credentials, err := userRepository.FindWebauthnCredentialsByUserID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.Empty(t, credentials)
+
+		// Records the deletion
+		var deletion model.AccountDeletion
+		err = database.NewSelect().Model(&deletion).Where("user_id = ?", user.ID).Scan(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "password", deletion.Method)
+	})
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+)
+
+// AdminController serves operator endpoints, which are guarded by the admin token.
+type AdminController struct {
+	Limiter ratelimit.Limiter
+}
+
+// UnlockAccount lifts the password lockout of the account with the given username.
+func (handler AdminController) UnlockAccount() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+		if len(p.Username) == 0 {
+			return sendError(ctx, "Empty username", http.StatusBadRequest)
+		}
+
+		if err := handler.Limiter.Unlock(ctx.Request().Context(), p.Username); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		ctx.Logger().Infof("admin unlocked account %s", p.Username)
+
+		return sendOK(ctx)
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/repository"
+)
+
+// AntiEnumeration hides whether a username exists when Enabled. Its zero value is disabled.
+type AntiEnumeration struct {
+	Enabled bool
+	// Derives the decoy credentials of unknown usernames, so they stay the same across requests
+	key []byte
+	// Compared against when there is no password hash, so the response takes as long as for a wrong
+	// password. It is created with the configured parameters, so the comparison costs the same.
+	dummyHash string
+}
+
+func NewAntiEnumeration(cfg config.AuthConfig, sessionConfig config.SessionConfig) (AntiEnumeration, error) {
+	if !cfg.AntiEnumeration {
+		return AntiEnumeration{}, nil
+	}
+
+	dummyHash, err := NewPasswordHasher(cfg).Hash("anti-enumeration")
+	if err != nil {
+		return AntiEnumeration{}, err
+	}
+
+	return AntiEnumeration{
+		Enabled:   true,
+		key:       []byte(sessionConfig.Secret),
+		dummyHash: dummyHash,
+	}, nil
+}
+
+// compareDummyPassword spends the time of a password comparison without a password hash.
+func (a AntiEnumeration) compareDummyPassword(password string) {
+	_, _ = argon2id.ComparePasswordAndHash(password, a.dummyHash)
+}
+
+// rejectSignUp answers a sign-up whose username is taken or reserved by a registration in
+// progress. With anti-enumeration the reason is only written to the log.
+func (a AntiEnumeration) rejectSignUp(ctx echo.Context, method string, username string, err error) error {
+	if a.Enabled {
+		ctx.Logger().Infof("%s sign-up for %q failed: %v", method, username, err)
+		return sendError(ctx, "Registration failed.", http.StatusBadRequest)
+	}
+	if errors.Is(err, repository.ErrRegistrationPending) {
+		return sendError(ctx, "A registration for this username is already in progress.", http.StatusConflict)
+	}
+	return sendError(ctx, "An account with that username already exists.", http.StatusConflict)
+}
+
+// decoyUser returns a user with a single made-up passkey, used in place of a user without
+// passkeys. Its ID and credential ID look random but are the same for every request.
+func (a AntiEnumeration) decoyUser(username string) *model.User {
+	id := a.derive("user", username)
+	// Random UUIDs are version 4, variant 10
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+	userID, _ := uuid.FromBytes(id[:16])
+
+	return &model.User{
+		ID:       userID,
+		Username: username,
+		WebauthnCredentials: []model.WebauthnCredentials{{
+			UserID:       userID,
+			CredentialID: a.derive("credential", username),
+			Transport:    []protocol.AuthenticatorTransport{protocol.Internal, protocol.Hybrid},
+		}},
+	}
+}
+
+func (a AntiEnumeration) derive(purpose string, username string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(purpose + ":" + username))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAntiEnumeration(t *testing.T) AntiEnumeration {
+	antiEnumeration, err := NewAntiEnumeration(config.AuthConfig{
+		AntiEnumeration:         true,
+		PasswordHashMemory:      64 * 1024,
+		PasswordHashIterations:  1,
+		PasswordHashParallelism: 2,
+	}, config.SessionConfig{Secret: "secret"})
+	assert.NoError(t, err)
+	return antiEnumeration
+}
+
+func TestAntiEnumeration_PasswordLogin(t *testing.T) {
+	controller := passwordController
+	controller.AntiEnumeration = newAntiEnumeration(t)
+
+	passwordHash, err := argon2id.CreateHash("password123", argon2id.DefaultParams)
+	assert.NoError(t, err)
+	createPasskeyUser(t, "enum_password_user", passwordHash, 1)
+	createPasskeyUser(t, "enum_passkey_only_user", "", 1)
+
+	login := func(t *testing.T, username string, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/login", strings.NewReader(`{"username":"`+username+`", "password":"`+password+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, withSession(controller.Login())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("failures get the same response", func(t *testing.T) {
+		for _, username := range []string{"enum_unknown_user", "enum_passkey_only_user", "enum_password_user"} {
+			rec := login(t, username, "wrongPassword")
+			assert.Equal(t, http.StatusUnauthorized, rec.Code, username)
+			assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid username or password."}`, rec.Body.String(), username)
+		}
+	})
+
+	t.Run("unknown usernames are locked out like existing ones", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, login(t, "enum_locked_user", "wrong1").Code)
+		assert.Equal(t, http.StatusUnauthorized, login(t, "enum_locked_user", "wrong2").Code)
+		assert.Equal(t, http.StatusTooManyRequests, login(t, "enum_locked_user", "wrong3").Code)
+	})
+
+	t.Run("successful login", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, login(t, "enum_password_user", "password123").Code)
+	})
+}
+
+func TestAntiEnumeration_PasswordSignUp(t *testing.T) {
+	controller := passwordController
+	controller.AntiEnumeration = newAntiEnumeration(t)
+
+	req := httptest.NewRequest(echo.POST, "/signup", strings.NewReader(`{"username":"existing_user", "password":"password123"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(t, withSession(controller.SignUp())(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"status": "error", "errorMessage":"Registration failed."}`, rec.Body.String())
+}
+
+func TestAntiEnumeration_Passkey(t *testing.T) {
+	webAuthnController := newWebAuthnController(t)
+	webAuthnController.AntiEnumeration = newAntiEnumeration(t)
+	createPasskeyUser(t, "enum_login_user", "", 1)
+
+	beginLogin := func(t *testing.T, username string) []protocol.CredentialDescriptor {
+		req := httptest.NewRequest(echo.POST, "/login/begin", strings.NewReader(`{"username":"`+username+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, webAuthnController.BeginLogin()(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "login", rec.Result().Cookies()[0].Name)
+
+		var options protocol.CredentialAssertion
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &options))
+		return options.Response.AllowedCredentials
+	}
+
+	t.Run("unknown username gets stable decoy options", func(t *testing.T) {
+		allowed := beginLogin(t, "enum_unknown_user")
+		assert.Len(t, allowed, 1)
+		assert.Equal(t, allowed, beginLogin(t, "enum_unknown_user"))
+		assert.NotEqual(t, allowed, beginLogin(t, "enum_other_unknown_user"))
+		assert.Len(t, beginLogin(t, "enum_login_user"), 1)
+	})
+
+	t.Run("begin registration does not reveal taken usernames", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/register/begin", strings.NewReader(`{"username":"existing_user"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, webAuthnController.BeginRegistration()(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
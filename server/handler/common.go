@@ -1,17 +1,31 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+	"time"
 
-	"github.com/gorilla/sessions"
+	"github.com/google/uuid"
 	"github.com/labstack/echo-contrib/session"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/repository"
 
 	"github.com/labstack/echo/v4"
 )
 
 type Params struct {
-	Username string
-	Password string
+	Username   string
+	Password   string
+	RememberMe bool
+	// TOTP or recovery code
+	Code  string
+	Email string
+	// Token of a link sent by email
+	Token string
+	// Required besides the new Password when changing it
+	CurrentPassword string `json:"current_password"`
 }
 
 type Response struct {
@@ -33,26 +47,337 @@ func sendOK(ctx echo.Context) error {
 	})
 }
 
-func createSession(ctx echo.Context, userId string) error {
-	sess, err := session.Get("auth", ctx)
+const (
+	AuthMethodPasskey      = "passkey"
+	AuthMethodPassword     = "password"
+	AuthMethodRecoveryCode = "recovery_code"
+)
+
+// loginSessionTouchInterval limits how often the last-seen time of a session is written.
+const loginSessionTouchInterval = time.Minute
+
+// secondFactorTimeout and recoveryTimeout limit how long a login can wait for the second factor,
+// and for the new passkey after a login with a recovery code.
+const (
+	secondFactorTimeout = 5 * time.Minute
+	recoveryTimeout     = 15 * time.Minute
+)
+
+var (
+	errNotLoggedIn    = errors.New("not logged in")
+	errNoPendingLogin = errors.New("no login waiting for a second factor")
+	errNoRecovery     = errors.New("no login waiting for a new passkey")
+)
+
+// ErrSessionExpired is returned for sessions past their idle timeout or absolute lifetime.
+var ErrSessionExpired = errors.New("session expired")
+
+// SessionManager handles the sessions of signed-in users. The cookie only references a server-side
+// login session, so sessions can be listed and revoked. The reference is replaced on every privilege
+// change, so an identifier planted or observed before it is worthless afterwards.
+type SessionManager struct {
+	// Name of the session cookie
+	Name              string
+	SessionRepository repository.SessionRepository
+	Cookies           Cookies
+	// Sessions end after IdleTimeout without activity and AbsoluteLifetime after login, or
+	// RememberMeIdleTimeout and RememberMeLifetime if remembered
+	IdleTimeout           time.Duration
+	AbsoluteLifetime      time.Duration
+	RememberMeIdleTimeout time.Duration
+	RememberMeLifetime    time.Duration
+	// Sensitive operations require a login or re-authentication within ReauthWindow
+	ReauthWindow time.Duration
+}
+
+func NewSessionManager(cfg config.SessionConfig, sessionRepository repository.SessionRepository, cookies Cookies) SessionManager {
+	return SessionManager{
+		Name:                  cfg.Name,
+		SessionRepository:     sessionRepository,
+		Cookies:               cookies,
+		IdleTimeout:           cfg.IdleTimeout,
+		AbsoluteLifetime:      cfg.AbsoluteLifetime,
+		RememberMeIdleTimeout: cfg.RememberMeIdleTimeout,
+		RememberMeLifetime:    cfg.RememberMeLifetime,
+		ReauthWindow:          cfg.ReauthWindow,
+	}
+}
+
+// Create signs the user in, recording how they authenticated. Any session the client
+// presented before is revoked. Remembered sessions last longer and survive longer idle periods.
+func (sm SessionManager) Create(ctx echo.Context, userID uuid.UUID, authMethod string, rememberMe bool) error {
+	if err := sm.revokePrevious(ctx); err != nil {
+		return err
+	}
+
+	loginSession := sm.newLoginSession(ctx, userID, authMethod, rememberMe)
+	if err := sm.SessionRepository.CreateLoginSession(ctx.Request().Context(), loginSession); err != nil {
+		return err
+	}
+
+	return sm.save(ctx, loginSession)
+}
+
+// CreatePending starts a login whose first factor succeeded. The session does not sign the
+// user in until Complete, and ends after secondFactorTimeout.
+func (sm SessionManager) CreatePending(ctx echo.Context, userID uuid.UUID, authMethod string, rememberMe bool) error {
+	return sm.createIncomplete(ctx, userID, authMethod, rememberMe, secondFactorTimeout, func(loginSession *model.LoginSession) {
+		loginSession.SecondFactorPending = true
+	})
+}
+
+// CreateRecovery starts a login with a recovery code. The session only allows registering a new
+// passkey and does not sign the user in until Complete. It ends after recoveryTimeout.
+func (sm SessionManager) CreateRecovery(ctx echo.Context, userID uuid.UUID) error {
+	return sm.createIncomplete(ctx, userID, AuthMethodRecoveryCode, false, recoveryTimeout, func(loginSession *model.LoginSession) {
+		loginSession.PasskeyRequired = true
+	})
+}
+
+func (sm SessionManager) createIncomplete(ctx echo.Context, userID uuid.UUID, authMethod string, rememberMe bool, timeout time.Duration, update func(loginSession *model.LoginSession)) error {
+	if err := sm.revokePrevious(ctx); err != nil {
+		return err
+	}
+
+	loginSession := sm.newLoginSession(ctx, userID, authMethod, rememberMe)
+	loginSession.ExpiresAt = loginSession.CreatedAt.Add(timeout)
+	loginSession.ReauthenticatedAt = nil
+	update(loginSession)
+	if err := sm.SessionRepository.CreateLoginSession(ctx.Request().Context(), loginSession); err != nil {
+		return err
+	}
+
+	return sm.save(ctx, loginSession)
+}
+
+// Complete signs the user of an incomplete session in, once they took the missing step. The
+// incomplete session is replaced, so it can only be completed once.
+func (sm SessionManager) Complete(ctx echo.Context, incomplete *model.LoginSession) error {
+	loginSession := sm.newLoginSession(ctx, incomplete.UserID, incomplete.AuthMethod, incomplete.RememberMe)
+	if err := sm.SessionRepository.ReplaceLoginSession(ctx.Request().Context(), incomplete.ID, loginSession); err != nil {
+		return err
+	}
+
+	return sm.save(ctx, loginSession)
+}
+
+// revokePrevious revokes the session the client presented, if any.
+func (sm SessionManager) revokePrevious(ctx echo.Context) error {
+	previous, err := sm.find(ctx)
+	if err != nil {
+		return nil
+	}
+
+	err = sm.SessionRepository.DeleteLoginSession(ctx.Request().Context(), previous.UserID, previous.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+func (sm SessionManager) newLoginSession(ctx echo.Context, userID uuid.UUID, authMethod string, rememberMe bool) *model.LoginSession {
+	now := time.Now()
+	lifetime := sm.AbsoluteLifetime
+	if rememberMe {
+		lifetime = sm.RememberMeLifetime
+	}
+	return &model.LoginSession{
+		ID:                uuid.New(),
+		UserID:            userID,
+		IP:                ctx.RealIP(),
+		UserAgent:         ctx.Request().UserAgent(),
+		AuthMethod:        authMethod,
+		RememberMe:        rememberMe,
+		CreatedAt:         now,
+		LastSeenAt:        now,
+		ExpiresAt:         now.Add(lifetime),
+		ReauthenticatedAt: &now,
+	}
+}
+
+// Rotate moves the current login session to a new identifier, e.g. after the password changed.
+// The lifetime of the session is kept.
+func (sm SessionManager) Rotate(ctx echo.Context) error {
+	return sm.replace(ctx, func(loginSession *model.LoginSession) {})
+}
+
+// Reauthenticate records that the user just proved their identity again. Like every privilege
+// change, it moves the session to a new identifier.
+func (sm SessionManager) Reauthenticate(ctx echo.Context) error {
+	return sm.replace(ctx, func(loginSession *model.LoginSession) {
+		now := time.Now()
+		loginSession.ReauthenticatedAt = &now
+	})
+}
+
+// replace swaps the current login session for an updated copy with a new identifier.
+func (sm SessionManager) replace(ctx echo.Context, update func(loginSession *model.LoginSession)) error {
+	current, err := sm.Session(ctx)
+	if err != nil {
+		return err
+	}
+
+	replacement := *current
+	replacement.ID = uuid.New()
+	replacement.IP = ctx.RealIP()
+	replacement.UserAgent = ctx.Request().UserAgent()
+	replacement.LastSeenAt = time.Now()
+	update(&replacement)
+	if err := sm.SessionRepository.ReplaceLoginSession(ctx.Request().Context(), current.ID, &replacement); err != nil {
+		return err
+	}
+
+	return sm.save(ctx, &replacement)
+}
+
+// RecentlyAuthenticated reports whether the user logged in or re-authenticated within the ReauthWindow.
+func (sm SessionManager) RecentlyAuthenticated(loginSession *model.LoginSession) bool {
+	return loginSession.ReauthenticatedAt != nil && time.Since(*loginSession.ReauthenticatedAt) < sm.ReauthWindow
+}
+
+// Renew slides the idle timeout of the session forward on activity.
+func (sm SessionManager) Renew(ctx echo.Context, loginSession *model.LoginSession) error {
+	now := time.Now()
+	if now.Sub(loginSession.LastSeenAt) < loginSessionTouchInterval {
+		return nil
+	}
+
+	if err := sm.SessionRepository.TouchLoginSession(ctx.Request().Context(), loginSession.ID, now); err != nil {
+		return err
+	}
+	loginSession.LastSeenAt = now
+
+	return sm.save(ctx, loginSession)
+}
+
+// save points the session cookie to the login session, dropping everything else the
+// client's cookie contained. The cookie expires together with the session.
+func (sm SessionManager) save(ctx echo.Context, loginSession *model.LoginSession) error {
+	sess, err := session.Get(sm.Name, ctx)
 	if err != nil {
 		return err
 	}
-	sess.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   24 * 60 * 60,
-		HttpOnly: true,
+
+	sess.Values = map[interface{}]interface{}{
+		"session": loginSession.ID.String(),
 	}
-	sess.Values["user"] = userId
+	sess.Options = sm.Cookies.SessionOptions(int(time.Until(sm.expiresAt(loginSession)).Seconds()))
 	return sess.Save(ctx.Request(), ctx.Response())
 }
 
-func terminateSession(ctx echo.Context) error {
-	sess, err := session.Get("auth", ctx)
+// expiresAt returns when the session ends unless there is further activity.
+func (sm SessionManager) expiresAt(loginSession *model.LoginSession) time.Time {
+	idleTimeout := sm.IdleTimeout
+	if loginSession.RememberMe {
+		idleTimeout = sm.RememberMeIdleTimeout
+	}
+	if idleExpiry := loginSession.LastSeenAt.Add(idleTimeout); idleExpiry.Before(loginSession.ExpiresAt) {
+		return idleExpiry
+	}
+
+	return loginSession.ExpiresAt
+}
+
+// Session returns the login session of the request, failing if it was revoked or still waits
+// for the second factor or a new passkey, and with ErrSessionExpired if it timed out.
+func (sm SessionManager) Session(ctx echo.Context) (*model.LoginSession, error) {
+	loginSession, err := sm.find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !loginSession.Complete() {
+		return nil, errNotLoggedIn
+	}
+
+	return loginSession, nil
+}
+
+// PendingSession returns the login session of the request if it waits for the second factor.
+func (sm SessionManager) PendingSession(ctx echo.Context) (*model.LoginSession, error) {
+	loginSession, err := sm.find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !loginSession.SecondFactorPending {
+		return nil, errNoPendingLogin
+	}
+
+	return loginSession, nil
+}
+
+// RecoverySession returns the login session of the request if it waits for a new passkey.
+func (sm SessionManager) RecoverySession(ctx echo.Context) (*model.LoginSession, error) {
+	loginSession, err := sm.find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !loginSession.PasskeyRequired {
+		return nil, errNoRecovery
+	}
+
+	return loginSession, nil
+}
+
+// find returns the login session of the request, whether or not it is complete.
+func (sm SessionManager) find(ctx echo.Context) (*model.LoginSession, error) {
+	sess, err := session.Get(sm.Name, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rawID, ok := sess.Values["session"].(string)
+	if !ok {
+		return nil, errNotLoggedIn
+	}
+	id, err := uuid.Parse(rawID)
+	if err != nil {
+		return nil, errNotLoggedIn
+	}
+
+	loginSession, err := sm.SessionRepository.FindLoginSession(ctx.Request().Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNotLoggedIn
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !time.Now().Before(sm.expiresAt(loginSession)) {
+		err = sm.SessionRepository.DeleteLoginSession(ctx.Request().Context(), loginSession.UserID, loginSession.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		return nil, ErrSessionExpired
+	}
+
+	return loginSession, nil
+}
+
+// UserID returns the ID of the signed-in user.
+func (sm SessionManager) UserID(ctx echo.Context) (uuid.UUID, error) {
+	loginSession, err := sm.Session(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return loginSession.UserID, nil
+}
+
+// Terminate revokes the login session of the request and clears the cookie.
+func (sm SessionManager) Terminate(ctx echo.Context) error {
+	sess, err := session.Get(sm.Name, ctx)
 	if err != nil {
 		return err
 	}
 
-	sess.Values["user"] = nil // Revoke users authentication
+	if loginSession, err := sm.find(ctx); err == nil {
+		err = sm.SessionRepository.DeleteLoginSession(ctx.Request().Context(), loginSession.UserID, loginSession.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	sess.Values = map[interface{}]interface{}{}
+	sess.Options = sm.Cookies.SessionOptions(-1)
 	return sess.Save(ctx.Request(), ctx.Response())
 }
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+// Cookies applies the configured attributes to every cookie the server sets.
+type Cookies struct {
+	Secure   bool
+	SameSite http.SameSite
+	Domain   string
+	// Prefix of the session cookie name, either __Host-, __Secure- or empty
+	Prefix string
+}
+
+func NewCookies(cfg config.SessionConfig) Cookies {
+	cookies := Cookies{
+		Secure: cfg.CookieSecure,
+		Domain: cfg.CookieDomain,
+	}
+
+	switch cfg.CookieSameSite {
+	case "strict":
+		cookies.SameSite = http.SameSiteStrictMode
+	case "none":
+		cookies.SameSite = http.SameSiteNoneMode
+	default:
+		cookies.SameSite = http.SameSiteLaxMode
+	}
+
+	for _, prefix := range []string{"__Host-", "__Secure-"} {
+		if strings.HasPrefix(cfg.Name, prefix) {
+			cookies.Prefix = prefix
+		}
+	}
+
+	return cookies
+}
+
+// Name prefixes the name of a cookie like the session cookie.
+func (c Cookies) Name(name string) string {
+	return c.Prefix + name
+}
+
+// New returns a cookie with the configured attributes. A negative maxAge deletes the cookie.
+func (c Cookies) New(name string, value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     c.Name(name),
+		Value:    value,
+		Path:     "/",
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+}
+
+// SessionOptions returns the attributes of the session cookie.
+func (c Cookies) SessionOptions(maxAge int) *sessions.Options {
+	return &sessions.Options{
+		Path:     "/",
+		Domain:   c.Domain,
+		MaxAge:   maxAge,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+}
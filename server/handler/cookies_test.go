@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCookies(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		cookies := NewCookies(config.SessionConfig{Name: "auth", CookieSameSite: "lax"})
+
+		cookie := cookies.New(string(CeremonyLogin), "id", 60)
+		assert.Equal(t, "login", cookie.Name)
+		assert.False(t, cookie.Secure)
+		assert.True(t, cookie.HttpOnly)
+		assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+	})
+
+	t.Run("__Host- prefix applies to ceremony cookies", func(t *testing.T) {
+		cookies := NewCookies(config.SessionConfig{Name: "__Host-auth", CookieSecure: true, CookieSameSite: "strict"})
+
+		cookie := cookies.New(string(CeremonyLogin), "id", 60)
+		assert.Equal(t, "__Host-login", cookie.Name)
+		assert.True(t, cookie.Secure)
+		assert.Equal(t, "/", cookie.Path)
+		assert.Empty(t, cookie.Domain)
+		assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+
+		options := cookies.SessionOptions(60)
+		assert.True(t, options.Secure)
+		assert.Equal(t, http.SameSiteStrictMode, options.SameSite)
+	})
+}
@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/mailer"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/repository"
+	"github.com/shangsuru/passkey-demo/signedtoken"
+)
+
+// EmailVerificationPath is the page of the web app that verification links open. It passes the
+// token of the link on to VerifyEmail.
+const EmailVerificationPath = "/verify-email"
+
+// Longest address that fits into the forward path of SMTP (RFC 5321)
+const maxEmailLength = 254
+
+// EmailVerification creates and checks the links that prove a user can read the emails sent to
+// an address. The links are signed and carry the address, so it is only stored once verified.
+// They also carry the EmailVersion of the user, so each link works once and stops working when
+// the address changes in another way.
+type EmailVerification struct {
+	signer   signedtoken.Signer
+	origin   string
+	lifetime time.Duration
+}
+
+func NewEmailVerification(cfg config.MailConfig, webAuthnConfig config.WebAuthnConfig, sessionConfig config.SessionConfig) EmailVerification {
+	return EmailVerification{
+		signer:   signedtoken.NewSigner(sessionConfig.Secret, "verify-email"),
+		origin:   cfg.Origin(webAuthnConfig),
+		lifetime: cfg.VerificationLinkLifetime,
+	}
+}
+
+type emailClaims struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Email   string    `json:"email"`
+	Version int64     `json:"version"`
+}
+
+// link returns a link for verifying email as the address of the user, and when it expires.
+func (v EmailVerification) link(user *model.User, email string, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(v.lifetime)
+	token, err := v.signer.Sign(emailClaims{UserID: user.ID, Email: email, Version: user.EmailVersion}, expiresAt)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return v.origin + EmailVerificationPath + "?" + url.Values{"token": {token}}.Encode(), expiresAt, nil
+}
+
+func (v EmailVerification) verify(token string, now time.Time) (emailClaims, error) {
+	var claims emailClaims
+	err := v.signer.Verify(token, now, &claims)
+	return claims, err
+}
+
+// EmailController manages the optional email address of an account.
+type EmailController struct {
+	UserRepository    repository.UserRepository
+	SessionManager    SessionManager
+	Mailer            mailer.Mailer
+	EmailVerification EmailVerification
+	// The relying party name is shown as the sender of the emails
+	WebAuthnConfig config.WebAuthnConfig
+}
+
+type EmailResponse struct {
+	Response
+	Email      string     `json:"email"`
+	VerifiedAt *time.Time `json:"verified_at"`
+}
+
+// GetEmail returns the verified email address of the signed-in user, which is empty if there is none.
+func (handler EmailController) GetEmail() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return ctx.JSON(http.StatusOK, EmailResponse{
+			Response:   Response{Status: "ok"},
+			Email:      user.Email,
+			VerifiedAt: user.EmailVerifiedAt,
+		})
+	}
+}
+
+// ChangeEmail sends a verification link to the given address. The address replaces the current
+// one of the signed-in user once the link is opened. Whether another account uses the address
+// is only checked then, so this does not tell which addresses are registered.
+func (handler EmailController) ChangeEmail() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+		email, ok := normalizeEmail(p.Email)
+		if !ok {
+			return sendError(ctx, "Invalid email address.", http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		if user.Email == email {
+			return sendError(ctx, "This email address is already verified.", http.StatusConflict)
+		}
+
+		link, expiresAt, err := handler.EmailVerification.link(user, email, time.Now())
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		appName := handler.WebAuthnConfig.RPDisplayName
+		err = handler.Mailer.Send(ctx.Request().Context(), mailer.Message{
+			To:      email,
+			Subject: "Verify your email address for " + appName,
+			Body: fmt.Sprintf("Hi %s,\n\nopen this link to use this email address for your %s account:\n\n%s\n\n"+
+				"The link expires on %s. If you did not ask for this, you can ignore this email.\n",
+				user.Username, appName, link, expiresAt.UTC().Format("January 2, 2006 at 15:04 MST")),
+		})
+		if err != nil {
+			ctx.Logger().Errorf("failed to send verification email to user %s: %v", user.ID, err)
+			return sendError(ctx, "The email could not be sent. Please try again later.", http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// VerifyEmail stores the address of a verification link as the email address of its user. It does
+// not need a session, as the link may be opened in another browser.
+func (handler EmailController) VerifyEmail() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+
+		claims, err := handler.EmailVerification.verify(p.Token, time.Now())
+		if errors.Is(err, signedtoken.ErrExpired) {
+			return sendError(ctx, "The link has expired. Please request a new one.", http.StatusBadRequest)
+		}
+		if err != nil {
+			return sendError(ctx, "Invalid link.", http.StatusBadRequest)
+		}
+
+		err = handler.UserRepository.SetEmail(ctx.Request().Context(), claims.UserID, claims.Email, claims.Version)
+		if errors.Is(err, repository.ErrEmailChanged) {
+			return sendError(ctx, "The link was already used or replaced. Please request a new one.", http.StatusBadRequest)
+		}
+		if errors.Is(err, repository.ErrEmailTaken) {
+			return sendError(ctx, "This email address is already in use.", http.StatusConflict)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			// The account was deleted in the meantime
+			return sendError(ctx, "Invalid link.", http.StatusBadRequest)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// RemoveEmail removes the email address of the signed-in user.
+func (handler EmailController) RemoveEmail() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		if err := handler.UserRepository.RemoveEmail(ctx.Request().Context(), userID); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// normalizeEmail accepts a bare address like alice@example.com, without a display name, and
+// lowercases it so every address is stored in only one way.
+func normalizeEmail(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+	address, err := mail.ParseAddress(s)
+	if err != nil || address.Address != s || len(s) > maxEmailLength {
+		return "", false
+	}
+
+	return strings.ToLower(s), true
+}
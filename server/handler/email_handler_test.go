@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/mailer"
+	"github.com/stretchr/testify/assert"
+)
+
+// newEmailController returns a controller whose emails are written to the returned outbox.
+func newEmailController() (EmailController, *bytes.Buffer) {
+	var outbox bytes.Buffer
+	webAuthnConfig := config.WebAuthnConfig{RPDisplayName: "Passkey Demo", RPOrigins: []string{"http://localhost:9044"}}
+	return EmailController{
+		UserRepository: userRepository,
+		SessionManager: sessionManager,
+		Mailer:         mailer.NewWriterOutbox("no-reply@localhost", &outbox),
+		EmailVerification: NewEmailVerification(
+			config.MailConfig{VerificationLinkLifetime: time.Hour},
+			webAuthnConfig,
+			config.SessionConfig{Secret: "secret"},
+		),
+		WebAuthnConfig: webAuthnConfig,
+	}, &outbox
+}
+
+var verificationLink = regexp.MustCompile(`http://localhost:9044/verify-email\?token=\S+`)
+
+// lastToken returns the token of the last verification link in the outbox.
+func lastToken(t *testing.T, outbox *bytes.Buffer) string {
+	// Long lines are wrapped by the quoted-printable encoding
+	body := strings.ReplaceAll(outbox.String(), "=\n", "")
+	links := verificationLink.FindAllString(strings.ReplaceAll(body, "=3D", "="), -1)
+	if !assert.NotEmpty(t, links) {
+		return ""
+	}
+	link, err := url.Parse(links[len(links)-1])
+	assert.NoError(t, err)
+	return link.Query().Get("token")
+}
+
+func TestEmailController(t *testing.T) {
+	emailController, outbox := newEmailController()
+
+	request := func(t *testing.T, method string, h echo.HandlerFunc, cookie *http.Cookie, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/account/email", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(h)(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("the address is stored once the link is opened", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "email_verify_user", "", 1)
+		cookie := loginAs(t, user.ID)
+
+		rec := request(t, echo.PUT, emailController.ChangeEmail(), cookie, `{"email":" Alice@Example.com "}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, outbox.String(), "To: alice@example.com\n")
+		assert.Contains(t, outbox.String(), "email_verify_user")
+
+		rec = request(t, echo.GET, emailController.GetEmail(), cookie, "")
+		assert.Contains(t, rec.Body.String(), `"email":""`)
+
+		// Opened in another browser without a session
+		rec = request(t, echo.POST, emailController.VerifyEmail(), nil, `{"token":"`+lastToken(t, outbox)+`"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = request(t, echo.GET, emailController.GetEmail(), cookie, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"email":"alice@example.com"`)
+
+		rec = request(t, echo.PUT, emailController.ChangeEmail(), cookie, `{"email":"alice@example.com"}`)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+
+		rec = request(t, echo.DELETE, emailController.RemoveEmail(), cookie, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.Empty(t, user.Email)
+	})
+
+	t.Run("a link works once and only for the current address", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "email_replay_user", "", 1)
+		cookie := loginAs(t, user.ID)
+
+		rec := request(t, echo.PUT, emailController.ChangeEmail(), cookie, `{"email":"first@example.com"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		first := lastToken(t, outbox)
+		rec = request(t, echo.PUT, emailController.ChangeEmail(), cookie, `{"email":"second@example.com"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		second := lastToken(t, outbox)
+
+		rec = request(t, echo.POST, emailController.VerifyEmail(), nil, `{"token":"`+second+`"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		for _, token := range []string{first, second} {
+			rec = request(t, echo.POST, emailController.VerifyEmail(), nil, `{"token":"`+token+`"}`)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.JSONEq(t, `{"status": "error", "errorMessage":"The link was already used or replaced. Please request a new one."}`, rec.Body.String())
+		}
+
+		// Nor after the address was removed
+		rec = request(t, echo.DELETE, emailController.RemoveEmail(), cookie, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec = request(t, echo.POST, emailController.VerifyEmail(), nil, `{"token":"`+second+`"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.Empty(t, user.Email)
+	})
+
+	t.Run("an address verified by another account", func(t *testing.T) {
+		owner, _ := createPasskeyUser(t, "email_owner_user", "", 1)
+		assert.NoError(t, userRepository.SetEmail(context.Background(), owner.ID, "taken@example.com", 0))
+		user, _ := createPasskeyUser(t, "email_taken_user", "", 1)
+
+		rec := request(t, echo.PUT, emailController.ChangeEmail(), loginAs(t, user.ID), `{"email":"taken@example.com"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = request(t, echo.POST, emailController.VerifyEmail(), nil, `{"token":"`+lastToken(t, outbox)+`"}`)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"This email address is already in use."}`, rec.Body.String())
+	})
+
+	t.Run("expired and forged links", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "email_expired_user", "", 1)
+		link, _, err := emailController.EmailVerification.link(user, "expired@example.com", time.Now().Add(-2*time.Hour))
+		assert.NoError(t, err)
+		token := link[strings.Index(link, "token=")+len("token="):]
+
+		rec := request(t, echo.POST, emailController.VerifyEmail(), nil, `{"token":"`+token+`"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"The link has expired. Please request a new one."}`, rec.Body.String())
+
+		rec = request(t, echo.POST, emailController.VerifyEmail(), nil, `{"token":"`+token+`x"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid link."}`, rec.Body.String())
+	})
+
+	t.Run("invalid addresses", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "email_invalid_user", "", 1)
+		cookie := loginAs(t, user.ID)
+
+		for _, email := range []string{"", "alice", "Alice <alice@example.com>", "alice@example.com\r\nBcc: mallory@example.com"} {
+			rec := request(t, echo.PUT, emailController.ChangeEmail(), cookie, `{"email":"`+strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(email)+`"}`)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, email)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/mds"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/provider"
+	"github.com/shangsuru/passkey-demo/repository"
+)
+
+const maxPasskeyNameLength = 255
+
+type PasskeyController struct {
+	UserRepository repository.UserRepository
+	SessionManager SessionManager
+	Metadata       *mds.Service
+	Providers      provider.Registry
+}
+
+type RenamePasskeyParams struct {
+	Name string
+}
+
+type Passkey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	// Model of the authenticator according to the metadata, if known
+	Authenticator string `json:"authenticator,omitempty"`
+	// Password manager, platform or security key holding the passkey, if known
+	Provider  string `json:"provider,omitempty"`
+	IconLight string `json:"icon_light,omitempty"`
+	IconDark  string `json:"icon_dark,omitempty"`
+}
+
+type PasskeysResponse struct {
+	Response
+	Passkeys []Passkey `json:"passkeys"`
+}
+
+func (handler PasskeyController) newPasskey(credential model.WebauthnCredentials) Passkey {
+	passkey := Passkey{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		CreatedAt:  credential.CreatedAt,
+		LastUsedAt: credential.LastUsedAt,
+	}
+	if entry, ok := handler.Metadata.Lookup(credential.AAGUID); ok {
+		passkey.Authenticator = entry.MetadataStatement.Description
+		passkey.IconLight = entry.MetadataStatement.Icon
+		passkey.IconDark = entry.MetadataStatement.Icon
+	}
+	// Provider icons come in variants for light and dark backgrounds, so they are preferred
+	if p, ok := handler.Providers.Lookup(credential.AAGUID); ok {
+		passkey.Provider = p.Name
+		if p.IconLight != "" {
+			passkey.IconLight = p.IconLight
+			passkey.IconDark = p.IconDark
+		}
+	}
+	return passkey
+}
+
+func (handler PasskeyController) ListPasskeys() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		credentials, err := handler.UserRepository.FindWebauthnCredentialsByUserID(ctx.Request().Context(), userID)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		passkeys := make([]Passkey, len(credentials))
+		for i, credential := range credentials {
+			passkeys[i] = handler.newPasskey(credential)
+		}
+
+		return ctx.JSON(http.StatusOK, PasskeysResponse{
+			Response: Response{Status: "ok"},
+			Passkeys: passkeys,
+		})
+	}
+}
+
+func (handler PasskeyController) RenamePasskey() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		id, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			return sendError(ctx, "Invalid passkey ID", http.StatusBadRequest)
+		}
+
+		var p RenamePasskeyParams
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+		name := strings.TrimSpace(p.Name)
+		if len(name) == 0 {
+			return sendError(ctx, "Empty name", http.StatusBadRequest)
+		}
+		if len(name) > maxPasskeyNameLength {
+			return sendError(ctx, "Name is too long", http.StatusBadRequest)
+		}
+
+		err = handler.UserRepository.RenameWebauthnCredential(ctx.Request().Context(), userID, id, name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "Passkey not found.", http.StatusNotFound)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+func (handler PasskeyController) DeletePasskey() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		id, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			return sendError(ctx, "Invalid passkey ID", http.StatusBadRequest)
+		}
+
+		err = handler.UserRepository.DeleteWebauthnCredential(ctx.Request().Context(), userID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "Passkey not found.", http.StatusNotFound)
+		}
+		if errors.Is(err, repository.ErrLastSignInMethod) {
+			return sendError(ctx, "You cannot delete your only way to sign in.", http.StatusConflict)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
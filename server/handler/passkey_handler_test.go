@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+// loginAs returns an auth cookie for the given user.
+func loginAs(t *testing.T, userID uuid.UUID) *http.Cookie {
+	req := httptest.NewRequest(echo.POST, "/login", nil)
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+
+	assert.NoError(t, withSession(func(ctx echo.Context) error {
+		return sessionManager.Create(ctx, userID, AuthMethodPasskey, false)
+	})(ctx))
+	return rec.Result().Cookies()[0]
+}
+
+// createPasskeyUser inserts a user with the given password hash and number of credentials.
+func createPasskeyUser(t *testing.T, username string, passwordHash string, credentials int) (*model.User, []uuid.UUID) {
+	user, err := userRepository.CreateUser(context.Background(), username, passwordHash)
+	assert.NoError(t, err)
+
+	var ids []uuid.UUID
+	for i := 0; i < credentials; i++ {
+		credential := &model.WebauthnCredentials{
+			ID:              uuid.New(),
+			UserID:          user.ID,
+			CredentialID:    []byte(uuid.NewString()),
+			PublicKey:       []byte("public key"),
+			AttestationType: "none",
+		}
+		_, err := database.NewInsert().
+			Model(credential).
+			Column("id", "user_id", "credential_id", "public_key", "attestation_type", "flags", "authenticator").
+			Exec(context.Background())
+		assert.NoError(t, err)
+		ids = append(ids, credential.ID)
+	}
+
+	return user, ids
+}
+
+func TestPasskeyController_ListPasskeys(t *testing.T) {
+	passkeyController := PasskeyController{UserRepository: userRepository, SessionManager: sessionManager}
+
+	t.Run("not logged in", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/api/passkeys", nil)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(passkeyController.ListPasskeys())(ctx))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Not logged in."}`, rec.Body.String())
+	})
+
+	t.Run("lists only own passkeys", func(t *testing.T) {
+		user, ids := createPasskeyUser(t, "list_user", "", 2)
+		_, _ = createPasskeyUser(t, "other_list_user", "", 1)
+
+		req := httptest.NewRequest(echo.GET, "/api/passkeys", nil)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(passkeyController.ListPasskeys())(ctx))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		for _, id := range ids {
+			assert.Contains(t, rec.Body.String(), id.String())
+		}
+		assert.Equal(t, 2, strings.Count(rec.Body.String(), `"name":"Passkey"`))
+	})
+
+	t.Run("shows the provider of known authenticators", func(t *testing.T) {
+		providers, err := provider.NewRegistry(config.ProvidersConfig{})
+		assert.NoError(t, err)
+		passkeyController := PasskeyController{UserRepository: userRepository, SessionManager: sessionManager, Providers: providers}
+
+		user, ids := createPasskeyUser(t, "provider_list_user", "", 1)
+		_, err = database.NewUpdate().
+			Model((*model.WebauthnCredentials)(nil)).
+			Set("aaguid = ?", uuid.MustParse("fbfc3007-154e-4ecc-8c0b-6e020557d7bd")).
+			Where("id = ?", ids[0]).
+			Exec(context.Background())
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(echo.GET, "/api/passkeys", nil)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, withSession(passkeyController.ListPasskeys())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var response PasskeysResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, "iCloud Keychain", response.Passkeys[0].Provider)
+		assert.NotEmpty(t, response.Passkeys[0].IconLight)
+		assert.NotEmpty(t, response.Passkeys[0].IconDark)
+	})
+}
+
+func TestPasskeyController_RenamePasskey(t *testing.T) {
+	passkeyController := PasskeyController{UserRepository: userRepository, SessionManager: sessionManager}
+	user, ids := createPasskeyUser(t, "rename_user", "", 1)
+	_, otherIDs := createPasskeyUser(t, "other_rename_user", "", 1)
+
+	rename := func(id string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.PATCH, "/api/passkeys/"+id, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(id)
+
+		assert.NoError(t, withSession(passkeyController.RenamePasskey())(ctx))
+		return rec
+	}
+
+	t.Run("empty name", func(t *testing.T) {
+		rec := rename(ids[0].String(), `{"name":"  "}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Empty name"}`, rec.Body.String())
+	})
+
+	t.Run("passkey of another user", func(t *testing.T) {
+		rec := rename(otherIDs[0].String(), `{"name":"Stolen"}`)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Passkey not found."}`, rec.Body.String())
+	})
+
+	t.Run("successful rename", func(t *testing.T) {
+		rec := rename(ids[0].String(), `{"name":"YubiKey"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		credentials, err := userRepository.FindWebauthnCredentialsByUserID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "YubiKey", credentials[0].Name)
+	})
+}
+
+func TestPasskeyController_DeletePasskey(t *testing.T) {
+	passkeyController := PasskeyController{UserRepository: userRepository, SessionManager: sessionManager}
+
+	deletePasskey := func(userID uuid.UUID, id uuid.UUID) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.DELETE, "/api/passkeys/"+id.String(), nil)
+		req.AddCookie(loginAs(t, userID))
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		ctx.SetParamNames("id")
+		ctx.SetParamValues(id.String())
+
+		assert.NoError(t, withSession(passkeyController.DeletePasskey())(ctx))
+		return rec
+	}
+
+	t.Run("last passkey of a passkey-only account", func(t *testing.T) {
+		user, ids := createPasskeyUser(t, "delete_last_user", "", 1)
+
+		rec := deletePasskey(user.ID, ids[0])
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"You cannot delete your only way to sign in."}`, rec.Body.String())
+	})
+
+	t.Run("last passkey of an account with a password", func(t *testing.T) {
+		user, ids := createPasskeyUser(t, "delete_password_user", "hash", 1)
+
+		rec := deletePasskey(user.ID, ids[0])
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("one of several passkeys", func(t *testing.T) {
+		user, ids := createPasskeyUser(t, "delete_many_user", "", 2)
+
+		rec := deletePasskey(user.ID, ids[0])
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		credentials, err := userRepository.FindWebauthnCredentialsByUserID(context.Background(), user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, credentials, 1)
+		assert.Equal(t, ids[1], credentials[0].ID)
+	})
+
+	t.Run("unknown passkey", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "delete_unknown_user", "", 1)
+
+		rec := deletePasskey(user.ID, uuid.New())
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
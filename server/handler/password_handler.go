@@ -1,16 +1,46 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/mailer"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/ratelimit"
 	"github.com/shangsuru/passkey-demo/repository"
 
 	"github.com/alexedwards/argon2id"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
+const minPasswordLength = 8
+
+// SecondFactorTOTP asks the client for a code from the user's authenticator app.
+const SecondFactorTOTP = "totp"
+
 type PasswordController struct {
-	UserRepository repository.UserRepository
+	UserRepository    repository.UserRepository
+	SessionRepository repository.SessionRepository
+	SessionManager    SessionManager
+	Limiter           ratelimit.Limiter
+	AntiEnumeration   AntiEnumeration
+	PasswordHasher    PasswordHasher
+	// Password reset links are sent by email and point to the web app
+	Mailer         mailer.Mailer
+	MailConfig     config.MailConfig
+	WebAuthnConfig config.WebAuthnConfig
+}
+
+// LoginResponse tells the client what completes the login, if anything: a second factor, or
+// registering a new passkey after a login with a recovery code.
+type LoginResponse struct {
+	Response
+	SecondFactor    string `json:"second_factor,omitempty"`
+	PasskeyRequired bool   `json:"passkey_required,omitempty"`
 }
 
 func (handler PasswordController) SignUp() echo.HandlerFunc {
@@ -24,26 +54,26 @@ func (handler PasswordController) SignUp() echo.HandlerFunc {
 			return sendError(ctx, "Empty username", http.StatusBadRequest)
 		}
 		password := p.Password
-		if len(password) < 8 {
-			return sendError(ctx, "Password must be at least 8 characters", http.StatusBadRequest)
+		if problem := checkPassword(password); problem != "" {
+			return sendError(ctx, problem, http.StatusBadRequest)
 		}
 
-		_, err := handler.UserRepository.FindUserByUsername(ctx.Request().Context(), username)
-		if err == nil {
-			return sendError(ctx, "An account with that username already exists.", http.StatusConflict)
-		}
-
-		passwordHash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+		// Hashing first makes a taken username take as long as a free one. Only its response
+		// differs, as a successful sign-up necessarily shows that the username was free.
+		passwordHash, err := handler.PasswordHasher.Hash(password)
 		if err != nil {
 			return sendError(ctx, "Internal server error", http.StatusInternalServerError)
 		}
 
 		user, err := handler.UserRepository.CreateUser(ctx.Request().Context(), username, passwordHash)
+		if errors.Is(err, repository.ErrUsernameTaken) || errors.Is(err, repository.ErrRegistrationPending) {
+			return handler.AntiEnumeration.rejectSignUp(ctx, "password", username, err)
+		}
 		if err != nil {
 			return sendError(ctx, "Internal server error", http.StatusInternalServerError)
 		}
 
-		if err = createSession(ctx, user.ID.String()); err != nil {
+		if err = handler.SessionManager.Create(ctx, user.ID, AuthMethodPassword, false); err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
@@ -62,21 +92,62 @@ func (handler PasswordController) Login() echo.HandlerFunc {
 			return sendError(ctx, "Empty username", http.StatusBadRequest)
 		}
 
+		// Locked accounts are rejected before spending time on hashing
+		lockedFor, err := handler.Limiter.LockedFor(ctx.Request().Context(), username)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		if lockedFor > 0 {
+			return sendLockedOut(ctx, lockedFor)
+		}
+
 		user, err := handler.UserRepository.FindUserByUsername(ctx.Request().Context(), username)
 		if err != nil {
+			if handler.AntiEnumeration.Enabled {
+				handler.AntiEnumeration.compareDummyPassword(p.Password)
+				return handler.failLogin(ctx, username, "unknown username")
+			}
 			return sendError(ctx, "An account with that username does not exist.", http.StatusNotFound)
 		}
 
+		if !user.HasPassword() {
+			if handler.AntiEnumeration.Enabled {
+				handler.AntiEnumeration.compareDummyPassword(p.Password)
+				return handler.failLogin(ctx, username, "account has no password")
+			}
+			return sendError(ctx, "This account has no password. Please sign in with your passkey.", http.StatusBadRequest)
+		}
+
 		match, err := argon2id.ComparePasswordAndHash(p.Password, user.PasswordHash)
 		if err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
 		if !match {
-			return sendError(ctx, "Invalid password.", http.StatusUnauthorized)
+			return handler.failLogin(ctx, username, "wrong password")
+		}
+
+		if handler.PasswordHasher.NeedsRehash(user.PasswordHash) {
+			handler.rehashPassword(ctx, user.ID, user.PasswordHash, p.Password)
+		}
+
+		// The lockout is only lifted once the code was checked as well, so that codes
+		// cannot be guessed without limit
+		if user.TOTPEnabled() {
+			if err = handler.SessionManager.CreatePending(ctx, user.ID, AuthMethodPassword, p.RememberMe); err != nil {
+				return sendError(ctx, "Session could not be created", http.StatusInternalServerError)
+			}
+			return ctx.JSON(http.StatusOK, LoginResponse{
+				Response:     Response{Status: "ok"},
+				SecondFactor: SecondFactorTOTP,
+			})
 		}
 
-		if err = createSession(ctx, user.ID.String()); err != nil {
+		if err := handler.Limiter.Unlock(ctx.Request().Context(), username); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err = handler.SessionManager.Create(ctx, user.ID, AuthMethodPassword, p.RememberMe); err != nil {
 			return sendError(ctx, "Session could not be created", http.StatusInternalServerError)
 		}
 
@@ -84,11 +155,226 @@ func (handler PasswordController) Login() echo.HandlerFunc {
 	}
 }
 
+// failLogin counts a failed password login towards the lockout of the username. Unknown
+// usernames are only counted with anti-enumeration, so that they get locked out alike.
+func (handler PasswordController) failLogin(ctx echo.Context, username string, reason string) error {
+	if handler.AntiEnumeration.Enabled {
+		ctx.Logger().Infof("password login for %q failed: %s", username, reason)
+	}
+
+	lockedFor, err := handler.Limiter.Fail(ctx.Request().Context(), username)
+	if err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+	if lockedFor > 0 {
+		return sendLockedOut(ctx, lockedFor)
+	}
+	if handler.AntiEnumeration.Enabled {
+		return sendError(ctx, "Invalid username or password.", http.StatusUnauthorized)
+	}
+	return sendError(ctx, "Invalid password.", http.StatusUnauthorized)
+}
+
+// rehashPassword replaces a hash with weaker parameters than the configured ones, which is only
+// possible while the password is at hand. A failure does not stop the login.
+func (handler PasswordController) rehashPassword(ctx echo.Context, userID uuid.UUID, oldHash string, password string) {
+	passwordHash, err := handler.PasswordHasher.Hash(password)
+	if err == nil {
+		err = handler.UserRepository.ReplacePasswordHash(ctx.Request().Context(), userID, oldHash, passwordHash)
+	}
+	// The password was changed concurrently, which makes the new hash obsolete
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		ctx.Logger().Errorf("failed to rehash the password of user %s: %v", userID, err)
+	}
+}
+
+// confirmPassword checks the password of a signed-in user before a sensitive operation. Like at
+// login, locked accounts are rejected and wrong passwords count towards the lockout, so a stolen
+// session cannot be used to guess the password. It returns false once it sent the response.
+func confirmPassword(ctx echo.Context, limiter ratelimit.Limiter, user *model.User, password string) (bool, error) {
+	lockedFor, err := limiter.LockedFor(ctx.Request().Context(), user.Username)
+	if err != nil {
+		return false, sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+	if lockedFor > 0 {
+		return false, sendLockedOut(ctx, lockedFor)
+	}
+
+	match, err := argon2id.ComparePasswordAndHash(password, user.PasswordHash)
+	if err != nil {
+		return false, sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+	if !match {
+		lockedFor, err := limiter.Fail(ctx.Request().Context(), user.Username)
+		if err != nil {
+			return false, sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		if lockedFor > 0 {
+			return false, sendLockedOut(ctx, lockedFor)
+		}
+		return false, sendError(ctx, "Invalid password.", http.StatusUnauthorized)
+	}
+
+	return true, nil
+}
+
+// checkPassword returns why a new password is not accepted, or an empty string if it is.
+func checkPassword(password string) string {
+	if len(password) < minPasswordLength {
+		return "Password must be at least 8 characters"
+	}
+	return ""
+}
+
+// sendLockedOut tells the client when the account can try a password again.
+func sendLockedOut(ctx echo.Context, lockedFor time.Duration) error {
+	ctx.Response().Header().Set(echo.HeaderRetryAfter, ratelimit.RetryAfter(lockedFor))
+	return sendError(ctx, "Too many failed attempts. Please try again later or sign in with your passkey.", http.StatusTooManyRequests)
+}
+
 func (handler PasswordController) Logout() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		if err := terminateSession(ctx); err != nil {
+		if _, err := handler.SessionManager.UserID(ctx); err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+		if err := handler.SessionManager.Terminate(ctx); err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+		return sendOK(ctx)
+	}
+}
+
+// SetPassword adds a password to a passkey-only account of the signed-in user.
+func (handler PasswordController) SetPassword() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
 			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
 		}
+
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+		password := p.Password
+		if problem := checkPassword(password); problem != "" {
+			return sendError(ctx, problem, http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if len(user.WebauthnCredentials) == 0 {
+			return sendError(ctx, "There is no passkey associated with this account.", http.StatusBadRequest)
+		}
+
+		passwordHash, err := handler.PasswordHasher.Hash(password)
+		if err != nil {
+			return sendError(ctx, "Internal server error", http.StatusInternalServerError)
+		}
+
+		err = handler.UserRepository.SetPassword(ctx.Request().Context(), user.ID, passwordHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "This account already has a password.", http.StatusConflict)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.SessionManager.Rotate(ctx); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// ChangePassword replaces the password of the signed-in user, who has to enter the current one.
+// Wrong passwords count towards the lockout of the account like failed logins. All other sessions
+// end, so whoever may have known the old password is signed out.
+func (handler PasswordController) ChangePassword() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		current, err := handler.SessionManager.Session(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+		if problem := checkPassword(p.Password); problem != "" {
+			return sendError(ctx, problem, http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), current.UserID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		if !user.HasPassword() {
+			return sendError(ctx, "This account has no password.", http.StatusBadRequest)
+		}
+
+		if ok, err := confirmPassword(ctx, handler.Limiter, user, p.CurrentPassword); !ok {
+			return err
+		}
+
+		passwordHash, err := handler.PasswordHasher.Hash(p.Password)
+		if err != nil {
+			return sendError(ctx, "Internal server error", http.StatusInternalServerError)
+		}
+
+		err = handler.UserRepository.ReplacePasswordHash(ctx.Request().Context(), user.ID, user.PasswordHash, passwordHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "The password was changed in the meantime.", http.StatusConflict)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.Limiter.Unlock(ctx.Request().Context(), user.Username); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		n, err := handler.SessionRepository.DeleteOtherLoginSessions(ctx.Request().Context(), user.ID, current.ID)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		ctx.Logger().Infof("user %s changed their password, ending %d other sessions", user.ID, n)
+
+		if err := handler.SessionManager.Rotate(ctx); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// RemovePassword turns the signed-in user's account into a passkey-only account.
+func (handler PasswordController) RemovePassword() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		err = handler.UserRepository.RemovePassword(ctx.Request().Context(), userID)
+		if errors.Is(err, repository.ErrLastSignInMethod) {
+			return sendError(ctx, "Register a passkey before removing your password.", http.StatusConflict)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.SessionManager.Rotate(ctx); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
 		return sendOK(ctx)
 	}
 }
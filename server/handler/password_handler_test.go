@@ -7,13 +7,17 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/alexedwards/argon2id"
+	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
 	"github.com/shangsuru/passkey-demo/db"
 	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/ratelimit"
 	"github.com/shangsuru/passkey-demo/repository"
 	"github.com/stretchr/testify/assert"
 	"github.com/uptrace/bun"
@@ -22,18 +26,48 @@ import (
 var (
 	database           *bun.DB
 	e                  *echo.Echo
+	store              sessions.Store
+	sessionManager     SessionManager
 	userRepository     repository.UserRepository
+	sessionRepository  repository.SessionRepository
+	limiter            ratelimit.Limiter
 	passwordController PasswordController
 )
 
 func setup() {
 	e = echo.New()
-	e.Use(session.Middleware(sessions.NewCookieStore([]byte("secret"))))
+	// Like the server without trusted proxies
+	e.IPExtractor = echo.ExtractIPDirect()
+	store = sessions.NewCookieStore([]byte("secret"))
 	database = db.GetTestDB()
 	userRepository = repository.UserRepository{DB: database}
+	sessionRepository = repository.SessionRepository{DB: database}
+	sessionManager = SessionManager{
+		Name:                  "auth",
+		SessionRepository:     sessionRepository,
+		IdleTimeout:           time.Hour,
+		AbsoluteLifetime:      24 * time.Hour,
+		RememberMeIdleTimeout: 7 * 24 * time.Hour,
+		RememberMeLifetime:    30 * 24 * time.Hour,
+		ReauthWindow:          5 * time.Minute,
+	}
+
+	limiter = ratelimit.NewLimiter(ratelimit.NewMemoryStore(time.Hour), config.RateLimitConfig{
+		Window:             time.Minute,
+		PerIP:              100,
+		PerUsername:        100,
+		Global:             100,
+		LockoutThreshold:   3,
+		LockoutWindow:      time.Hour,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: time.Hour,
+	})
 
 	passwordController = PasswordController{
-		UserRepository: userRepository,
+		UserRepository:    userRepository,
+		SessionRepository: sessionRepository,
+		SessionManager:    sessionManager,
+		Limiter:           limiter,
 	}
 	loadFixtures()
 }
@@ -54,6 +88,12 @@ func loadFixtures() {
 	}
 }
 
+// withSession runs the handler behind the session middleware, since contexts
+// created by e.NewContext do not pass through the router's middleware chain.
+func withSession(h echo.HandlerFunc) echo.HandlerFunc {
+	return session.Middleware(store)(h)
+}
+
 func TestMain(m *testing.M) {
 	setup()
 	code := m.Run()
@@ -68,7 +108,7 @@ func TestPasswordController_SignUp(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.SignUp()(ctx))
+		assert.NoError(t, withSession(passwordController.SignUp())(ctx))
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
 		assert.JSONEq(t, `{"status": "error", "errorMessage":"Empty username"}`, rec.Body.String())
 	})
@@ -79,7 +119,7 @@ func TestPasswordController_SignUp(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.SignUp()(ctx))
+		assert.NoError(t, withSession(passwordController.SignUp())(ctx))
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
 		assert.JSONEq(t, `{"status": "error", "errorMessage":"Password must be at least 8 characters"}`, rec.Body.String())
 	})
@@ -90,7 +130,7 @@ func TestPasswordController_SignUp(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.SignUp()(ctx))
+		assert.NoError(t, withSession(passwordController.SignUp())(ctx))
 		assert.Equal(t, http.StatusConflict, rec.Code)
 		assert.JSONEq(t, `{"status": "error", "errorMessage":"An account with that username already exists."}`, rec.Body.String())
 	})
@@ -101,7 +141,7 @@ func TestPasswordController_SignUp(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.SignUp()(ctx))
+		assert.NoError(t, withSession(passwordController.SignUp())(ctx))
 		assert.Equal(t, http.StatusOK, rec.Code)
 		assert.JSONEq(t, `{"status": "ok", "errorMessage":""}`, rec.Body.String())
 
@@ -121,6 +161,23 @@ func TestPasswordController_SignUp(t *testing.T) {
 		//}
 		//assert.Equal(t, user.ID.String(), value)
 	})
+
+	t.Run("replaces the session the client already had", func(t *testing.T) {
+		user, err := userRepository.FindUserByUsername(context.Background(), "existing_user")
+		assert.NoError(t, err)
+		planted := loginAs(t, user.ID)
+
+		req := httptest.NewRequest(echo.POST, "/login", strings.NewReader(`{"username":"existing_user", "password":"password123"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(planted)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(passwordController.Login())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		cookie := rec.Result().Cookies()[0]
+		assert.NotEqual(t, uuid.Nil, findSessionID(t, cookie))
+		assert.Equal(t, uuid.Nil, findSessionID(t, planted))
+	})
 }
 
 func TestPasswordController_Login(t *testing.T) {
@@ -130,7 +187,7 @@ func TestPasswordController_Login(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.Login()(ctx))
+		assert.NoError(t, withSession(passwordController.Login())(ctx))
 		assert.Equal(t, http.StatusBadRequest, rec.Code)
 		assert.JSONEq(t, `{"status": "error", "errorMessage":"Empty username"}`, rec.Body.String())
 	})
@@ -141,7 +198,7 @@ func TestPasswordController_Login(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.Login()(ctx))
+		assert.NoError(t, withSession(passwordController.Login())(ctx))
 		assert.Equal(t, http.StatusNotFound, rec.Code)
 		assert.JSONEq(t, `{"status": "error", "errorMessage":"An account with that username does not exist."}`, rec.Body.String())
 	})
@@ -152,18 +209,31 @@ func TestPasswordController_Login(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.Login()(ctx))
+		assert.NoError(t, withSession(passwordController.Login())(ctx))
 		assert.Equal(t, http.StatusUnauthorized, rec.Code)
 		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid password."}`, rec.Body.String())
 	})
 
+	t.Run("passkey-only account", func(t *testing.T) {
+		createPasskeyUser(t, "passkey_only_user", "", 1)
+
+		req := httptest.NewRequest(echo.POST, "/login", strings.NewReader(`{"username":"passkey_only_user", "password":"password123"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(passwordController.Login())(ctx))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"This account has no password. Please sign in with your passkey."}`, rec.Body.String())
+	})
+
 	t.Run("successful login", func(t *testing.T) {
 		req := httptest.NewRequest(echo.POST, "/login", strings.NewReader(`{"username":"existing_user", "password":"password123"}`))
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.Login()(ctx))
+		assert.NoError(t, withSession(passwordController.Login())(ctx))
 		assert.Equal(t, http.StatusOK, rec.Code)
 		assert.JSONEq(t, `{"status": "ok", "errorMessage":""}`, rec.Body.String())
 
@@ -180,6 +250,115 @@ func TestPasswordController_Login(t *testing.T) {
 		//}
 		//assert.Equal(t, user.ID.String(), value)
 	})
+
+	t.Run("replaces the session the client already had", func(t *testing.T) {
+		user, err := userRepository.FindUserByUsername(context.Background(), "existing_user")
+		assert.NoError(t, err)
+		planted := loginAs(t, user.ID)
+
+		req := httptest.NewRequest(echo.POST, "/login", strings.NewReader(`{"username":"existing_user", "password":"password123"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(planted)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(passwordController.Login())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		cookie := rec.Result().Cookies()[0]
+		assert.NotEqual(t, uuid.Nil, findSessionID(t, cookie))
+		assert.Equal(t, uuid.Nil, findSessionID(t, planted))
+	})
+}
+
+func TestPasswordController_LoginRehash(t *testing.T) {
+	weak := &argon2id.Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	strong := &argon2id.Params{Memory: 2048, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	rehashController := passwordController
+	rehashController.PasswordHasher = PasswordHasher{Params: strong}
+
+	login := func(t *testing.T, username string) {
+		req := httptest.NewRequest(echo.POST, "/login", strings.NewReader(`{"username":"`+username+`", "password":"password123"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(rehashController.Login())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	storedParams := func(t *testing.T, user *model.User) *argon2id.Params {
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		params, _, _, err := argon2id.DecodeHash(user.PasswordHash)
+		assert.NoError(t, err)
+		return params
+	}
+
+	t.Run("weaker hashes are replaced", func(t *testing.T) {
+		passwordHash, err := argon2id.CreateHash("password123", weak)
+		assert.NoError(t, err)
+		user, _ := createPasskeyUser(t, "rehash_weak_user", passwordHash, 0)
+
+		login(t, "rehash_weak_user")
+		assert.Equal(t, strong.Iterations, storedParams(t, user).Iterations)
+		assert.Equal(t, strong.Memory, storedParams(t, user).Memory)
+
+		// The new hash works
+		login(t, "rehash_weak_user")
+	})
+
+	t.Run("stronger hashes are kept", func(t *testing.T) {
+		stronger := &argon2id.Params{Memory: 4096, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+		passwordHash, err := argon2id.CreateHash("password123", stronger)
+		assert.NoError(t, err)
+		user, _ := createPasskeyUser(t, "rehash_strong_user", passwordHash, 0)
+
+		login(t, "rehash_strong_user")
+		assert.Equal(t, stronger.Memory, storedParams(t, user).Memory)
+	})
+}
+
+func TestPasswordController_Lockout(t *testing.T) {
+	passwordHash, err := argon2id.CreateHash("password123", argon2id.DefaultParams)
+	assert.NoError(t, err)
+	createPasskeyUser(t, "lockout_user", passwordHash, 1)
+
+	login := func(t *testing.T, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/login", strings.NewReader(`{"username":"lockout_user", "password":"`+password+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, withSession(passwordController.Login())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("locks the account after repeated failures", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, login(t, "wrong1").Code)
+		assert.Equal(t, http.StatusUnauthorized, login(t, "wrong2").Code)
+
+		rec := login(t, "wrong3")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.Equal(t, "60", rec.Header().Get(echo.HeaderRetryAfter))
+
+		// The correct password does not help while the account is locked
+		rec = login(t, "password123")
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Too many failed attempts. Please try again later or sign in with your passkey."}`, rec.Body.String())
+	})
+
+	t.Run("further failures double the lockout", func(t *testing.T) {
+		lockedFor, err := limiter.Fail(context.Background(), "lockout_user")
+		assert.NoError(t, err)
+		assert.Equal(t, 2*time.Minute, lockedFor)
+	})
+
+	t.Run("admin unlocks the account", func(t *testing.T) {
+		adminController := AdminController{Limiter: limiter}
+		req := httptest.NewRequest(echo.POST, "/admin/unlock", strings.NewReader(`{"username":"lockout_user"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, adminController.UnlockAccount()(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		assert.Equal(t, http.StatusOK, login(t, "password123").Code)
+	})
 }
 
 func TestPasswordController_Logout(t *testing.T) {
@@ -188,7 +367,7 @@ func TestPasswordController_Logout(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
 
-		assert.NoError(t, passwordController.Logout()(ctx))
+		assert.NoError(t, withSession(passwordController.Logout())(ctx))
 		assert.Equal(t, http.StatusUnauthorized, rec.Code)
 		assert.JSONEq(t, `{"status": "error", "errorMessage":"Not logged in."}`, rec.Body.String())
 	})
@@ -198,19 +377,177 @@ func TestPasswordController_Logout(t *testing.T) {
 		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 		rec := httptest.NewRecorder()
 		ctx := e.NewContext(req, rec)
-		assert.NoError(t, passwordController.Login()(ctx))
+		assert.NoError(t, withSession(passwordController.Login())(ctx))
 		cookie := rec.Result().Cookies()[0]
 
 		req = httptest.NewRequest(echo.POST, "/logout", nil)
 		req.AddCookie(cookie)
 		rec = httptest.NewRecorder()
 		ctx = e.NewContext(req, rec)
-		assert.NoError(t, passwordController.Logout()(ctx))
+		assert.NoError(t, withSession(passwordController.Logout())(ctx))
 		assert.Equal(t, http.StatusOK, rec.Code)
 		assert.JSONEq(t, `{"status": "ok", "errorMessage":""}`, rec.Body.String())
 
-		// It removes session information from the session store
-		//_, err := miniRedis.Get(cookie.Value)
-		//assert.Error(t, err)
+		// It revokes the server-side session, so a copy of the cookie is no longer accepted
+		req = httptest.NewRequest(echo.POST, "/logout", nil)
+		req.AddCookie(cookie)
+		rec = httptest.NewRecorder()
+		ctx = e.NewContext(req, rec)
+		assert.NoError(t, withSession(passwordController.Logout())(ctx))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestPasswordController_SetPassword(t *testing.T) {
+	setPassword := func(t *testing.T, user *model.User, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/api/account/password", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(passwordController.SetPassword())(ctx))
+		return rec
+	}
+
+	t.Run("password too short", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "set_password_short", "", 1)
+
+		rec := setPassword(t, user, `{"password":"short"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Password must be at least 8 characters"}`, rec.Body.String())
+	})
+
+	t.Run("account already has a password", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "set_password_existing", "hash", 1)
+
+		rec := setPassword(t, user, `{"password":"password123"}`)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"This account already has a password."}`, rec.Body.String())
+	})
+
+	t.Run("successfully set password", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "set_password_user", "", 1)
+
+		rec := setPassword(t, user, `{"password":"password123"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		user, err := userRepository.FindUserByUsername(context.Background(), user.Username)
+		assert.NoError(t, err)
+		assert.True(t, user.HasPassword())
+	})
+
+	t.Run("rotates the session", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "set_password_rotation_user", "", 1)
+		cookie := loginAs(t, user.ID)
+
+		req := httptest.NewRequest(echo.POST, "/api/account/password", strings.NewReader(`{"password":"password123"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(passwordController.SetPassword())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rotated := rec.Result().Cookies()[0]
+		assert.Equal(t, uuid.Nil, findSessionID(t, cookie))
+		assert.NotEqual(t, uuid.Nil, findSessionID(t, rotated))
+	})
+}
+
+func TestPasswordController_ChangePassword(t *testing.T) {
+	changePassword := func(t *testing.T, cookie *http.Cookie, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.PUT, "/api/account/password", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(passwordController.ChangePassword())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	createPasswordUser := func(t *testing.T, username string) *model.User {
+		passwordHash, err := argon2id.CreateHash("password123", argon2id.DefaultParams)
+		assert.NoError(t, err)
+		user, _ := createPasskeyUser(t, username, passwordHash, 0)
+		return user
+	}
+
+	t.Run("requires the current password", func(t *testing.T) {
+		user := createPasswordUser(t, "change_password_wrong_user")
+		cookie := loginAs(t, user.ID)
+
+		rec := changePassword(t, cookie, `{"current_password":"wrong", "password":"new password"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid password."}`, rec.Body.String())
+
+		rec = changePassword(t, cookie, `{"current_password":"wrong", "password":"new password"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		rec = changePassword(t, cookie, `{"current_password":"wrong", "password":"new password"}`)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	})
+
+	t.Run("password too short", func(t *testing.T) {
+		user := createPasswordUser(t, "change_password_short_user")
+
+		rec := changePassword(t, loginAs(t, user.ID), `{"current_password":"password123", "password":"short"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Password must be at least 8 characters"}`, rec.Body.String())
+	})
+
+	t.Run("passkey-only account", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "change_password_passkey_user", "", 1)
+
+		rec := changePassword(t, loginAs(t, user.ID), `{"current_password":"", "password":"new password"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("ends the other sessions and rotates the current one", func(t *testing.T) {
+		user := createPasswordUser(t, "change_password_user")
+		other := loginAs(t, user.ID)
+		cookie := loginAs(t, user.ID)
+
+		rec := changePassword(t, cookie, `{"current_password":"password123", "password":"new password"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rotated := rec.Result().Cookies()[0]
+		assert.Equal(t, uuid.Nil, findSessionID(t, other))
+		assert.Equal(t, uuid.Nil, findSessionID(t, cookie))
+		assert.NotEqual(t, uuid.Nil, findSessionID(t, rotated))
+
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		match, err := argon2id.ComparePasswordAndHash("new password", user.PasswordHash)
+		assert.NoError(t, err)
+		assert.True(t, match)
+	})
+}
+
+func TestPasswordController_RemovePassword(t *testing.T) {
+	removePassword := func(t *testing.T, user *model.User) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.DELETE, "/api/account/password", nil)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(passwordController.RemovePassword())(ctx))
+		return rec
+	}
+
+	t.Run("account without passkey", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "remove_password_no_passkey", "hash", 0)
+
+		rec := removePassword(t, user)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Register a passkey before removing your password."}`, rec.Body.String())
+	})
+
+	t.Run("successfully remove password", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "remove_password_user", "hash", 1)
+
+		rec := removePassword(t, user)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		user, err := userRepository.FindUserByUsername(context.Background(), user.Username)
+		assert.NoError(t, err)
+		assert.False(t, user.HasPassword())
 	})
 }
@@ -0,0 +1,49 @@
+package handler
+
+import (
+	"github.com/alexedwards/argon2id"
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+// PasswordHasher hashes passwords with the configured Argon2id parameters. Its zero value uses
+// the defaults of the argon2id package.
+type PasswordHasher struct {
+	Params *argon2id.Params
+}
+
+func NewPasswordHasher(cfg config.AuthConfig) PasswordHasher {
+	return PasswordHasher{Params: &argon2id.Params{
+		Memory:      uint32(cfg.PasswordHashMemory),
+		Iterations:  uint32(cfg.PasswordHashIterations),
+		Parallelism: uint8(cfg.PasswordHashParallelism),
+		SaltLength:  argon2id.DefaultParams.SaltLength,
+		KeyLength:   argon2id.DefaultParams.KeyLength,
+	}}
+}
+
+func (h PasswordHasher) params() *argon2id.Params {
+	if h.Params == nil {
+		return argon2id.DefaultParams
+	}
+	return h.Params
+}
+
+func (h PasswordHasher) Hash(password string) (string, error) {
+	return argon2id.CreateHash(password, h.params())
+}
+
+// NeedsRehash reports whether a stored hash is weaker than new hashes, i.e. it uses less memory,
+// fewer passes or a shorter salt or key. The number of lanes does not change the cost of
+// guessing, so a hash is not replaced for it alone.
+func (h PasswordHasher) NeedsRehash(hash string) bool {
+	stored, _, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return false
+	}
+
+	current := h.params()
+	return stored.Memory < current.Memory ||
+		stored.Iterations < current.Iterations ||
+		stored.SaltLength < current.SaltLength ||
+		stored.KeyLength < current.KeyLength
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasswordHasher_NeedsRehash(t *testing.T) {
+	hasher := PasswordHasher{Params: &argon2id.Params{Memory: 2048, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32}}
+
+	hash := func(t *testing.T, params argon2id.Params) string {
+		hash, err := argon2id.CreateHash("password123", &params)
+		assert.NoError(t, err)
+		return hash
+	}
+
+	current, err := hasher.Hash("password123")
+	assert.NoError(t, err)
+	assert.False(t, hasher.NeedsRehash(current))
+
+	assert.True(t, hasher.NeedsRehash(hash(t, argon2id.Params{Memory: 1024, Iterations: 2, Parallelism: 2, SaltLength: 16, KeyLength: 32})))
+	assert.True(t, hasher.NeedsRehash(hash(t, argon2id.Params{Memory: 2048, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32})))
+	assert.True(t, hasher.NeedsRehash(hash(t, argon2id.Params{Memory: 2048, Iterations: 2, Parallelism: 2, SaltLength: 8, KeyLength: 32})))
+	assert.False(t, hasher.NeedsRehash(hash(t, argon2id.Params{Memory: 2048, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32})))
+	assert.False(t, hasher.NeedsRehash(hash(t, argon2id.Params{Memory: 4096, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32})))
+	assert.False(t, hasher.NeedsRehash("not a hash"))
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/mailer"
+)
+
+// PasswordResetPath is the page of the web app that password reset links open. It asks for the
+// new password and passes it on to ResetPassword together with the token of the link.
+const PasswordResetPath = "/reset-password"
+
+// Bytes of randomness per reset token. Like recovery codes they are stored as plain SHA-256
+// hashes, which cannot be reversed at this length.
+const passwordResetTokenLength = 32
+
+// RequestPasswordReset sends a link for setting a new password to the email address, if it is the
+// verified address of an account with a password. The response is the same in every case and
+// the email is sent in the background, so neither tells whether there is such an account.
+func (handler PasswordController) RequestPasswordReset() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+		email, ok := normalizeEmail(p.Email)
+		if !ok {
+			return sendError(ctx, "Invalid email address.", http.StatusBadRequest)
+		}
+
+		// The request context ends with the response, and the echo context is reused
+		logger := ctx.Logger()
+		background := context.WithoutCancel(ctx.Request().Context())
+		go func() {
+			if err := handler.sendPasswordReset(background, email); err != nil {
+				logger.Errorf("password reset for %q failed: %v", email, err)
+			}
+		}()
+
+		return sendOK(ctx)
+	}
+}
+
+func (handler PasswordController) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := handler.UserRepository.FindUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("no account with this email address")
+	}
+	if err != nil {
+		return err
+	}
+	if !user.HasPassword() {
+		return errors.New("account has no password")
+	}
+
+	raw := make([]byte, passwordResetTokenLength)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	expiresAt := time.Now().Add(handler.MailConfig.PasswordResetLinkLifetime)
+	if err := handler.UserRepository.CreatePasswordResetToken(ctx, user.ID, hashPasswordResetToken(token), expiresAt); err != nil {
+		return err
+	}
+
+	link := handler.MailConfig.Origin(handler.WebAuthnConfig) + PasswordResetPath + "?" + url.Values{"token": {token}}.Encode()
+	appName := handler.WebAuthnConfig.RPDisplayName
+	return handler.Mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Reset your password for " + appName,
+		Body: fmt.Sprintf("Hi %s,\n\nopen this link to set a new password for your %s account:\n\n%s\n\n"+
+			"The link works once and expires on %s. If you did not ask for this, you can ignore this email.\n",
+			user.Username, appName, link, expiresAt.UTC().Format("January 2, 2006 at 15:04 MST")),
+	})
+}
+
+// ResetPassword sets a new password with the token of a password reset link. All sessions of the
+// account end, so whoever may have known the old password is signed out.
+func (handler PasswordController) ResetPassword() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+		if problem := checkPassword(p.Password); problem != "" {
+			return sendError(ctx, problem, http.StatusBadRequest)
+		}
+
+		passwordHash, err := handler.PasswordHasher.Hash(p.Password)
+		if err != nil {
+			return sendError(ctx, "Internal server error", http.StatusInternalServerError)
+		}
+
+		userID, err := handler.UserRepository.ResetPassword(ctx.Request().Context(), hashPasswordResetToken(p.Token), passwordHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "The link is invalid or has expired. Please request a new one.", http.StatusBadRequest)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		n, err := handler.SessionRepository.DeleteLoginSessionsByUserID(ctx.Request().Context(), userID)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		ctx.Logger().Infof("user %s reset their password, ending %d sessions", userID, n)
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		// Proving access to the email address is as good as a successful login
+		if err := handler.Limiter.Unlock(ctx.Request().Context(), user.Username); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+func hashPasswordResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/mailer"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// channelMailer hands the emails sent in the background over to the test.
+type channelMailer chan mailer.Message
+
+func (m channelMailer) Send(_ context.Context, msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+var passwordResetLink = regexp.MustCompile(`http://localhost:9044/reset-password\?token=\S+`)
+
+func TestPasswordController_ResetPassword(t *testing.T) {
+	outbox := make(channelMailer, 1)
+	passwordResetController := PasswordController{
+		UserRepository:    userRepository,
+		SessionRepository: sessionRepository,
+		SessionManager:    sessionManager,
+		Limiter:           limiter,
+		Mailer:            outbox,
+		MailConfig:        config.MailConfig{PasswordResetLinkLifetime: time.Hour},
+		WebAuthnConfig:    config.WebAuthnConfig{RPDisplayName: "Passkey Demo", RPOrigins: []string{"http://localhost:9044"}},
+	}
+
+	requestReset := func(t *testing.T, email string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/password/reset/request", strings.NewReader(`{"email":"`+email+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(passwordResetController.RequestPasswordReset())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	receiveToken := func(t *testing.T) string {
+		select {
+		case msg := <-outbox:
+			link, err := url.Parse(passwordResetLink.FindString(msg.Body))
+			assert.NoError(t, err)
+			return link.Query().Get("token")
+		case <-time.After(5 * time.Second):
+			t.Fatal("no password reset email was sent")
+			return ""
+		}
+	}
+
+	reset := func(t *testing.T, token string, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/password/reset", strings.NewReader(`{"token":"`+token+`", "password":"`+password+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(passwordResetController.ResetPassword())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	createResetUser := func(t *testing.T, username string, email string) *model.User {
+		passwordHash, err := argon2id.CreateHash("password123", argon2id.DefaultParams)
+		assert.NoError(t, err)
+		user, _ := createPasskeyUser(t, username, passwordHash, 0)
+		assert.NoError(t, userRepository.SetEmail(context.Background(), user.ID, email, 0))
+		return user
+	}
+
+	t.Run("sets the password once and ends all sessions", func(t *testing.T) {
+		user := createResetUser(t, "reset_password_user", "reset@example.com")
+		cookie := loginAs(t, user.ID)
+
+		rec := requestReset(t, "Reset@Example.com")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		token := receiveToken(t)
+
+		rec = reset(t, token, "short")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Password must be at least 8 characters"}`, rec.Body.String())
+
+		rec = reset(t, token, "new password")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, uuid.Nil, findSessionID(t, cookie))
+
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		match, err := argon2id.ComparePasswordAndHash("new password", user.PasswordHash)
+		assert.NoError(t, err)
+		assert.True(t, match)
+
+		rec = reset(t, token, "another password")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"The link is invalid or has expired. Please request a new one."}`, rec.Body.String())
+	})
+
+	t.Run("a new link replaces the previous one", func(t *testing.T) {
+		createResetUser(t, "reset_twice_user", "twice@example.com")
+
+		requestReset(t, "twice@example.com")
+		first := receiveToken(t)
+		requestReset(t, "twice@example.com")
+		second := receiveToken(t)
+
+		assert.Equal(t, http.StatusBadRequest, reset(t, first, "new password").Code)
+		assert.Equal(t, http.StatusOK, reset(t, second, "new password").Code)
+	})
+
+	t.Run("does not tell whether the account exists", func(t *testing.T) {
+		passkeyOnly, _ := createPasskeyUser(t, "reset_passkey_only_user", "", 1)
+		assert.NoError(t, userRepository.SetEmail(context.Background(), passkeyOnly.ID, "passkey@example.com", 0))
+
+		for _, email := range []string{"unknown@example.com", "passkey@example.com"} {
+			rec := requestReset(t, email)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.JSONEq(t, `{"status": "ok", "errorMessage":""}`, rec.Body.String())
+		}
+
+		select {
+		case msg := <-outbox:
+			t.Fatalf("unexpected email to %s", msg.To)
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		rec := reset(t, "made-up", "new password")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
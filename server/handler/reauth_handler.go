@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+	"github.com/shangsuru/passkey-demo/repository"
+)
+
+// ReauthController lets signed-in users prove their identity again before sensitive operations.
+type ReauthController struct {
+	UserRepository  repository.UserRepository
+	WebAuthnAPI     *webauthn.WebAuthn
+	WebAuthnSession WebAuthnSession
+	SessionManager  SessionManager
+	Limiter         ratelimit.Limiter
+}
+
+// BeginReauth starts a user-verifying passkey assertion restricted to the signed-in user's credentials.
+func (handler ReauthController) BeginReauth() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if len(user.WebauthnCredentials) == 0 {
+			return sendError(ctx, "There is no passkey associated with this account.", http.StatusBadRequest)
+		}
+
+		options, sessionData, err := handler.WebAuthnAPI.BeginLogin(user, webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.WebAuthnSession.Create(ctx, CeremonyReauth, sessionData); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return ctx.JSON(http.StatusOK, options)
+	}
+}
+
+// FinishReauth marks the session as recently authenticated after a successful passkey assertion.
+func (handler ReauthController) FinishReauth() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		sessionData, err := handler.WebAuthnSession.Get(ctx, CeremonyReauth)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+
+		if !bytes.Equal(sessionData.UserID, userID[:]) {
+			return sendError(ctx, "Confirmation was started by another account.", http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), sessionData.UserID)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		credential, err := handler.WebAuthnAPI.FinishLogin(user, *sessionData, ctx.Request())
+		if err != nil {
+			return sendError(ctx, "Passkey confirmation failed.", http.StatusUnauthorized)
+		}
+
+		if !credential.Flags.UserPresent || !credential.Flags.UserVerified {
+			return sendError(ctx, "User not present or not verified.", http.StatusBadRequest)
+		}
+
+		if credential.Authenticator.CloneWarning {
+			return sendError(ctx, "Authenticator is cloned.", http.StatusBadRequest)
+		}
+
+		err = handler.UserRepository.UpdateWebauthnCredentialAfterLogin(ctx.Request().Context(), credential)
+		if errors.Is(err, repository.ErrStaleSignCount) {
+			return sendError(ctx, "Authenticator is cloned.", http.StatusBadRequest)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.SessionManager.Reauthenticate(ctx); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// ReauthWithPassword marks the session as recently authenticated after confirming the password.
+func (handler ReauthController) ReauthWithPassword() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if !user.HasPassword() {
+			return sendError(ctx, "This account has no password.", http.StatusBadRequest)
+		}
+
+		if ok, err := confirmPassword(ctx, handler.Limiter, user, p.Password); !ok {
+			return err
+		}
+
+		if err := handler.SessionManager.Reauthenticate(ctx); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func newReauthController(t *testing.T) ReauthController {
+	webAuthnController := newWebAuthnController(t)
+	return ReauthController{
+		UserRepository:  webAuthnController.UserRepository,
+		WebAuthnAPI:     webAuthnController.WebAuthnAPI,
+		WebAuthnSession: webAuthnController.WebAuthnSession,
+		SessionManager:  webAuthnController.SessionManager,
+		Limiter:         webAuthnController.Limiter,
+	}
+}
+
+// expireReauthentication moves the last authentication of the session out of the re-authentication window.
+func expireReauthentication(t *testing.T, cookie *http.Cookie) {
+	_, err := database.NewUpdate().
+		Model((*model.LoginSession)(nil)).
+		Set("reauthenticated_at = ?", time.Now().Add(-time.Hour)).
+		Where("id = ?", findSessionID(t, cookie)).
+		Exec(context.Background())
+	assert.NoError(t, err)
+}
+
+func TestReauthController_BeginReauth(t *testing.T) {
+	reauthController := newReauthController(t)
+
+	t.Run("not logged in", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/reauth/begin", nil)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, withSession(reauthController.BeginReauth())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("restricted to the user's passkeys", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "begin_reauth_user", "", 1)
+
+		req := httptest.NewRequest(echo.POST, "/reauth/begin", nil)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, withSession(reauthController.BeginReauth())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"allowCredentials"`)
+		assert.Contains(t, rec.Body.String(), `"userVerification":"required"`)
+		assert.Equal(t, string(CeremonyReauth), rec.Result().Cookies()[0].Name)
+	})
+}
+
+func TestReauthController_ReauthWithPassword(t *testing.T) {
+	reauthController := newReauthController(t)
+	passwordHash, err := argon2id.CreateHash("password123", argon2id.DefaultParams)
+	assert.NoError(t, err)
+
+	reauth := func(t *testing.T, cookie *http.Cookie, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/reauth/password", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, withSession(reauthController.ReauthWithPassword())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("incorrect password", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "reauth_wrong_password", passwordHash, 1)
+
+		rec := reauth(t, loginAs(t, user.ID), `{"password":"wrongPassword"}`)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid password."}`, rec.Body.String())
+	})
+
+	t.Run("wrong passwords lock the account", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "reauth_lockout_user", passwordHash, 1)
+		cookie := loginAs(t, user.ID)
+
+		assert.Equal(t, http.StatusUnauthorized, reauth(t, cookie, `{"password":"wrong1"}`).Code)
+		assert.Equal(t, http.StatusUnauthorized, reauth(t, cookie, `{"password":"wrong2"}`).Code)
+		assert.Equal(t, http.StatusTooManyRequests, reauth(t, cookie, `{"password":"wrong3"}`).Code)
+		assert.Equal(t, http.StatusTooManyRequests, reauth(t, cookie, `{"password":"password123"}`).Code)
+	})
+
+	t.Run("renews the authentication and rotates the session", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "reauth_password_user", passwordHash, 1)
+		cookie := loginAs(t, user.ID)
+		expireReauthentication(t, cookie)
+
+		rec := reauth(t, cookie, `{"password":"password123"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rotated := rec.Result().Cookies()[0]
+		loginSession, err := sessionRepository.FindLoginSession(context.Background(), findSessionID(t, rotated))
+		assert.NoError(t, err)
+		assert.True(t, sessionManager.RecentlyAuthenticated(loginSession))
+		assert.Equal(t, uuid.Nil, findSessionID(t, cookie))
+	})
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+	"github.com/shangsuru/passkey-demo/recovery"
+	"github.com/shangsuru/passkey-demo/repository"
+)
+
+// RecoveryController manages the recovery codes that let users back into their account when
+// they lost their passkeys.
+type RecoveryController struct {
+	UserRepository repository.UserRepository
+	SessionManager SessionManager
+	Limiter        ratelimit.Limiter
+}
+
+type RecoveryCodesResponse struct {
+	Response
+	// Only shown once, as just their hashes are stored
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type RecoveryCodesLeftResponse struct {
+	Response
+	Left int `json:"left"`
+}
+
+// CountRecoveryCodes tells the signed-in user how many of their recovery codes are unused.
+func (handler RecoveryController) CountRecoveryCodes() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		left, err := handler.UserRepository.CountRecoveryCodes(ctx.Request().Context(), userID)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return ctx.JSON(http.StatusOK, RecoveryCodesLeftResponse{
+			Response: Response{Status: "ok"},
+			Left:     left,
+		})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the signed-in user's recovery codes with a new set.
+func (handler RecoveryController) RegenerateRecoveryCodes() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		codes, hashes, err := recovery.Generate()
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.UserRepository.ReplaceRecoveryCodes(ctx.Request().Context(), userID, hashes); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return ctx.JSON(http.StatusOK, RecoveryCodesResponse{
+			Response:      Response{Status: "ok"},
+			RecoveryCodes: codes,
+		})
+	}
+}
+
+// LoginWithRecoveryCode uses up one of the user's recovery codes. The login is only complete
+// once the user registered a new passkey with BeginRecoverPasskey and FinishRecoverPasskey.
+// Wrong codes count towards the lockout of the account like wrong passwords, and unknown
+// usernames get the same response as wrong codes.
+func (handler RecoveryController) LoginWithRecoveryCode() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+		username := p.Username
+		if len(username) == 0 {
+			return sendError(ctx, "Empty username", http.StatusBadRequest)
+		}
+
+		lockedFor, err := handler.Limiter.LockedFor(ctx.Request().Context(), username)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		if lockedFor > 0 {
+			return sendLockedOut(ctx, lockedFor)
+		}
+
+		user, err := handler.UserRepository.FindUserByUsername(ctx.Request().Context(), username)
+		if err != nil {
+			return handler.failLogin(ctx, username, "unknown username")
+		}
+
+		err = handler.UserRepository.UseRecoveryCode(ctx.Request().Context(), user.ID, recovery.Hash(p.Code))
+		if errors.Is(err, sql.ErrNoRows) {
+			return handler.failLogin(ctx, username, "wrong or used code")
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.SessionManager.CreateRecovery(ctx, user.ID); err != nil {
+			return sendError(ctx, "Session could not be created", http.StatusInternalServerError)
+		}
+
+		return ctx.JSON(http.StatusOK, LoginResponse{
+			Response:        Response{Status: "ok"},
+			PasskeyRequired: true,
+		})
+	}
+}
+
+// failLogin counts a failed recovery towards the lockout of the username.
+func (handler RecoveryController) failLogin(ctx echo.Context, username string, reason string) error {
+	ctx.Logger().Infof("recovery login for %q failed: %s", username, reason)
+
+	lockedFor, err := handler.Limiter.Fail(ctx.Request().Context(), username)
+	if err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+	if lockedFor > 0 {
+		return sendLockedOut(ctx, lockedFor)
+	}
+	return sendError(ctx, "Invalid username or recovery code.", http.StatusUnauthorized)
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/recovery"
+	"github.com/stretchr/testify/assert"
+)
+
+// createRecoveryUser inserts a passkey-only user with a set of recovery codes and returns the codes.
+func createRecoveryUser(t *testing.T, username string) (*model.User, []string) {
+	user, _ := createPasskeyUser(t, username, "", 1)
+
+	codes, hashes, err := recovery.Generate()
+	assert.NoError(t, err)
+	assert.NoError(t, userRepository.ReplaceRecoveryCodes(context.Background(), user.ID, hashes))
+	return user, codes
+}
+
+func TestRecoveryController_RegenerateRecoveryCodes(t *testing.T) {
+	recoveryController := RecoveryController{UserRepository: userRepository, SessionManager: sessionManager, Limiter: limiter}
+	user, oldCodes := createRecoveryUser(t, "regenerate_recovery_user")
+	cookie := loginAs(t, user.ID)
+
+	req := httptest.NewRequest(echo.POST, "/api/account/recovery_codes", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, withSession(recoveryController.RegenerateRecoveryCodes())(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var response RecoveryCodesResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Len(t, response.RecoveryCodes, recovery.Count)
+	assert.NotContains(t, response.RecoveryCodes, oldCodes[0])
+
+	req = httptest.NewRequest(echo.GET, "/api/account/recovery_codes", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	assert.NoError(t, withSession(recoveryController.CountRecoveryCodes())(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"status": "ok", "errorMessage":"", "left": 10}`, rec.Body.String())
+}
+
+func TestRecoveryController_LoginWithRecoveryCode(t *testing.T) {
+	recoveryController := RecoveryController{UserRepository: userRepository, SessionManager: sessionManager, Limiter: limiter}
+	webAuthnController := newWebAuthnController(t)
+
+	login := func(t *testing.T, username string, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/login/recovery", strings.NewReader(`{"username":"`+username+`", "code":"`+code+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(recoveryController.LoginWithRecoveryCode())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("a code only allows registering a new passkey", func(t *testing.T) {
+		_, codes := createRecoveryUser(t, "recovery_login_user")
+
+		rec := login(t, "recovery_login_user", strings.ToUpper(codes[0]))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"status": "ok", "errorMessage":"", "passkey_required": true}`, rec.Body.String())
+		cookie := rec.Result().Cookies()[0]
+		assert.Equal(t, uuid.Nil, findSessionID(t, cookie))
+
+		req := httptest.NewRequest(echo.POST, "/api/passkeys/register/begin", nil)
+		req.AddCookie(cookie)
+		rec = httptest.NewRecorder()
+		assert.NoError(t, withSession(webAuthnController.BeginAddPasskey())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		req = httptest.NewRequest(echo.POST, "/recovery/passkey/begin", nil)
+		req.AddCookie(cookie)
+		rec = httptest.NewRecorder()
+		assert.NoError(t, withSession(webAuthnController.BeginRecoverPasskey())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"excludeCredentials"`)
+	})
+
+	t.Run("codes work only once", func(t *testing.T) {
+		_, codes := createRecoveryUser(t, "recovery_reuse_user")
+
+		assert.Equal(t, http.StatusOK, login(t, "recovery_reuse_user", codes[0]).Code)
+
+		rec := login(t, "recovery_reuse_user", codes[0])
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid username or recovery code."}`, rec.Body.String())
+
+		assert.Equal(t, http.StatusOK, login(t, "recovery_reuse_user", codes[1]).Code)
+	})
+
+	t.Run("unknown username", func(t *testing.T) {
+		rec := login(t, "recovery_unknown_user", "abcd-efgh-ijkl-mnop")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid username or recovery code."}`, rec.Body.String())
+	})
+
+	t.Run("wrong codes lock the account", func(t *testing.T) {
+		_, codes := createRecoveryUser(t, "recovery_lockout_user")
+
+		assert.Equal(t, http.StatusUnauthorized, login(t, "recovery_lockout_user", "wrong1").Code)
+		assert.Equal(t, http.StatusUnauthorized, login(t, "recovery_lockout_user", "wrong2").Code)
+		assert.Equal(t, http.StatusTooManyRequests, login(t, "recovery_lockout_user", "wrong3").Code)
+		assert.Equal(t, http.StatusTooManyRequests, login(t, "recovery_lockout_user", codes[0]).Code)
+	})
+}
+
+func TestWebAuthnController_FinishRecoverPasskey(t *testing.T) {
+	webAuthnController := newWebAuthnController(t)
+
+	t.Run("without a login with a recovery code", func(t *testing.T) {
+		user, _ := createRecoveryUser(t, "recover_passkey_signed_in_user")
+
+		req := httptest.NewRequest(echo.POST, "/recovery/passkey/finish", nil)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(webAuthnController.FinishRecoverPasskey())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"No recovery in progress."}`, rec.Body.String())
+	})
+}
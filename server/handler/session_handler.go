@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/repository"
+)
+
+type SessionController struct {
+	SessionRepository repository.SessionRepository
+	SessionManager    SessionManager
+}
+
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	AuthMethod string    `json:"auth_method"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+type SessionsResponse struct {
+	Response
+	Sessions []Session `json:"sessions"`
+}
+
+func newSession(loginSession model.LoginSession, currentID uuid.UUID) Session {
+	return Session{
+		ID:         loginSession.ID,
+		IP:         loginSession.IP,
+		UserAgent:  loginSession.UserAgent,
+		AuthMethod: loginSession.AuthMethod,
+		CreatedAt:  loginSession.CreatedAt,
+		LastSeenAt: loginSession.LastSeenAt,
+		Current:    loginSession.ID == currentID,
+	}
+}
+
+// ListSessions returns the active sessions of the signed-in user, most recently used first.
+func (handler SessionController) ListSessions() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		current, err := handler.SessionManager.Session(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		now := time.Now()
+		loginSessions, err := handler.SessionRepository.FindLoginSessionsByUserID(ctx.Request().Context(), current.UserID,
+			now.Add(-handler.SessionManager.IdleTimeout), now.Add(-handler.SessionManager.RememberMeIdleTimeout))
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		sessions := make([]Session, len(loginSessions))
+		for i, loginSession := range loginSessions {
+			sessions[i] = newSession(loginSession, current.ID)
+		}
+
+		return ctx.JSON(http.StatusOK, SessionsResponse{
+			Response: Response{Status: "ok"},
+			Sessions: sessions,
+		})
+	}
+}
+
+// RevokeSession signs one of the user's sessions out. Revoking the current session logs out.
+func (handler SessionController) RevokeSession() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		current, err := handler.SessionManager.Session(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		id, err := uuid.Parse(ctx.Param("id"))
+		if err != nil {
+			return sendError(ctx, "Invalid session ID", http.StatusBadRequest)
+		}
+
+		if id == current.ID {
+			if err := handler.SessionManager.Terminate(ctx); err != nil {
+				return sendError(ctx, err.Error(), http.StatusInternalServerError)
+			}
+			return sendOK(ctx)
+		}
+
+		err = handler.SessionRepository.DeleteLoginSession(ctx.Request().Context(), current.UserID, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "Session not found.", http.StatusNotFound)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// RevokeOtherSessions signs the user out everywhere except in the current session.
+func (handler SessionController) RevokeOtherSessions() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		current, err := handler.SessionManager.Session(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		n, err := handler.SessionRepository.DeleteOtherLoginSessions(ctx.Request().Context(), current.UserID, current.ID)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		ctx.Logger().Infof("user %s revoked %d other sessions", current.UserID, n)
+
+		return sendOK(ctx)
+	}
+}
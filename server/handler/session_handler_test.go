@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/uptrace/bun"
+)
+
+func TestSessionController(t *testing.T) {
+	sessionController := SessionController{SessionRepository: sessionRepository, SessionManager: sessionManager}
+
+	request := func(t *testing.T, method string, id string, cookie *http.Cookie, h echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/sessions/"+id, nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+		if id != "" {
+			ctx.SetParamNames("id")
+			ctx.SetParamValues(id)
+		}
+
+		assert.NoError(t, withSession(h)(ctx))
+		return rec
+	}
+
+	t.Run("lists own sessions and marks the current one", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "list_sessions_user", "", 1)
+		_, _ = createPasskeyUser(t, "other_list_sessions_user", "", 1)
+		loginAs(t, user.ID)
+		cookie := loginAs(t, user.ID)
+
+		rec := request(t, echo.GET, "", cookie, sessionController.ListSessions())
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 2, strings.Count(rec.Body.String(), `"auth_method":"passkey"`))
+		assert.Equal(t, 1, strings.Count(rec.Body.String(), `"current":true`))
+	})
+
+	t.Run("records the address of the connection", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "session_ip_user", "", 1)
+		req := httptest.NewRequest(echo.POST, "/login", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.1")
+		assert.NoError(t, withSession(func(ctx echo.Context) error {
+			return sessionManager.Create(ctx, user.ID, AuthMethodPasskey, false)
+		})(e.NewContext(req, httptest.NewRecorder())))
+
+		sessions, err := sessionRepository.FindLoginSessionsByUserID(context.Background(), user.ID, time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		if assert.Len(t, sessions, 1) {
+			assert.Equal(t, "192.0.2.1", sessions[0].IP)
+		}
+	})
+
+	t.Run("expired sessions are not listed", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "list_expired_sessions_user", "", 1)
+		cookie := loginAs(t, user.ID)
+		now := time.Now()
+		for _, loginSession := range []model.LoginSession{
+			// Past the absolute lifetime
+			{AuthMethod: AuthMethodPasskey, RememberMe: true, LastSeenAt: now, ExpiresAt: now.Add(-time.Minute)},
+			// Idle for too long
+			{AuthMethod: AuthMethodPasskey, RememberMe: false, LastSeenAt: now.Add(-2 * sessionManager.IdleTimeout), ExpiresAt: now.Add(time.Hour)},
+			// Remembered sessions may be idle for longer
+			{AuthMethod: AuthMethodPassword, RememberMe: true, LastSeenAt: now.Add(-2 * sessionManager.IdleTimeout), ExpiresAt: now.Add(time.Hour)},
+		} {
+			loginSession.ID = uuid.New()
+			loginSession.UserID = user.ID
+			loginSession.CreatedAt = now.Add(-3 * sessionManager.IdleTimeout)
+			assert.NoError(t, sessionRepository.CreateLoginSession(context.Background(), &loginSession))
+		}
+
+		rec := request(t, echo.GET, "", cookie, sessionController.ListSessions())
+		assert.Equal(t, http.StatusOK, rec.Code)
+		// Only the current session and the remembered one
+		assert.Equal(t, 1, strings.Count(rec.Body.String(), `"auth_method":"passkey"`))
+		assert.Equal(t, 1, strings.Count(rec.Body.String(), `"auth_method":"password"`))
+	})
+
+	t.Run("revoked session can no longer be used", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "revoke_session_user", "", 1)
+		stolen := loginAs(t, user.ID)
+		cookie := loginAs(t, user.ID)
+		stolenSession := findSessionID(t, stolen)
+
+		rec := request(t, echo.DELETE, stolenSession.String(), cookie, sessionController.RevokeSession())
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = request(t, echo.GET, "", stolen, sessionController.ListSessions())
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		rec = request(t, echo.GET, "", cookie, sessionController.ListSessions())
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("session of another user", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "revoke_foreign_session_user", "", 1)
+		other, _ := createPasskeyUser(t, "revoke_foreign_session_victim", "", 1)
+		victimSession := findSessionID(t, loginAs(t, other.ID))
+
+		rec := request(t, echo.DELETE, victimSession.String(), loginAs(t, user.ID), sessionController.RevokeSession())
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Session not found."}`, rec.Body.String())
+	})
+
+	t.Run("revoke all other sessions", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "revoke_other_sessions_user", "", 1)
+		first := loginAs(t, user.ID)
+		second := loginAs(t, user.ID)
+		cookie := loginAs(t, user.ID)
+
+		rec := request(t, echo.DELETE, "", cookie, sessionController.RevokeOtherSessions())
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		for _, revoked := range []*http.Cookie{first, second} {
+			rec = request(t, echo.GET, "", revoked, sessionController.ListSessions())
+			assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		}
+		rec = request(t, echo.GET, "", cookie, sessionController.ListSessions())
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 1, strings.Count(rec.Body.String(), `"id"`))
+	})
+}
+
+func TestSessionManager_Timeouts(t *testing.T) {
+	login := func(t *testing.T, username string, rememberMe bool) (*http.Cookie, uuid.UUID) {
+		user, _ := createPasskeyUser(t, username, "", 1)
+		req := httptest.NewRequest(echo.POST, "/login", nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(func(ctx echo.Context) error {
+			return sessionManager.Create(ctx, user.ID, AuthMethodPasskey, rememberMe)
+		})(e.NewContext(req, rec)))
+
+		cookie := rec.Result().Cookies()[0]
+		return cookie, findSessionID(t, cookie)
+	}
+
+	session := func(t *testing.T, cookie *http.Cookie) error {
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		req.AddCookie(cookie)
+		return withSession(func(ctx echo.Context) error {
+			_, err := sessionManager.Session(ctx)
+			return err
+		})(e.NewContext(req, httptest.NewRecorder()))
+	}
+
+	update := func(t *testing.T, id uuid.UUID, column string, value time.Time) {
+		_, err := database.NewUpdate().
+			Model((*model.LoginSession)(nil)).
+			Set("? = ?", bun.Ident(column), value).
+			Where("id = ?", id).
+			Exec(context.Background())
+		assert.NoError(t, err)
+	}
+
+	t.Run("cookie expires with the idle timeout", func(t *testing.T) {
+		cookie, _ := login(t, "idle_cookie_user", false)
+		assert.InDelta(t, sessionManager.IdleTimeout.Seconds(), cookie.MaxAge, 5)
+	})
+
+	t.Run("idle session expires", func(t *testing.T) {
+		cookie, id := login(t, "idle_user", false)
+		update(t, id, "last_seen_at", time.Now().Add(-2*sessionManager.IdleTimeout))
+
+		assert.ErrorIs(t, session(t, cookie), ErrSessionExpired)
+		// The expired session is removed
+		assert.Equal(t, uuid.Nil, findSessionID(t, cookie))
+	})
+
+	t.Run("remembered session survives longer idle periods", func(t *testing.T) {
+		cookie, id := login(t, "remembered_user", true)
+		assert.InDelta(t, sessionManager.RememberMeIdleTimeout.Seconds(), cookie.MaxAge, 5)
+		update(t, id, "last_seen_at", time.Now().Add(-2*sessionManager.IdleTimeout))
+
+		assert.NoError(t, session(t, cookie))
+	})
+
+	t.Run("idle remembered session expires", func(t *testing.T) {
+		cookie, id := login(t, "idle_remembered_user", true)
+		update(t, id, "last_seen_at", time.Now().Add(-sessionManager.RememberMeIdleTimeout-time.Minute))
+
+		assert.ErrorIs(t, session(t, cookie), ErrSessionExpired)
+	})
+
+	t.Run("session expires after its absolute lifetime", func(t *testing.T) {
+		cookie, id := login(t, "absolute_user", true)
+		update(t, id, "expires_at", time.Now().Add(-time.Second))
+
+		assert.ErrorIs(t, session(t, cookie), ErrSessionExpired)
+	})
+
+	t.Run("activity renews the session", func(t *testing.T) {
+		cookie, id := login(t, "renewed_user", false)
+		lastSeen := time.Now().Add(-sessionManager.IdleTimeout / 2)
+		update(t, id, "last_seen_at", lastSeen)
+
+		req := httptest.NewRequest(echo.GET, "/", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(func(ctx echo.Context) error {
+			loginSession, err := sessionManager.Session(ctx)
+			if err != nil {
+				return err
+			}
+			return sessionManager.Renew(ctx, loginSession)
+		})(e.NewContext(req, rec)))
+
+		loginSession, err := sessionRepository.FindLoginSession(context.Background(), id)
+		assert.NoError(t, err)
+		assert.True(t, loginSession.LastSeenAt.After(lastSeen.Add(time.Minute)))
+		assert.InDelta(t, sessionManager.IdleTimeout.Seconds(), rec.Result().Cookies()[0].MaxAge, 5)
+	})
+}
+
+// findSessionID returns the ID of the login session referenced by the cookie, or uuid.Nil
+// if it was revoked.
+func findSessionID(t *testing.T, cookie *http.Cookie) uuid.UUID {
+	req := httptest.NewRequest(echo.GET, "/", nil)
+	req.AddCookie(cookie)
+	ctx := e.NewContext(req, httptest.NewRecorder())
+
+	var id uuid.UUID
+	assert.NoError(t, withSession(func(ctx echo.Context) error {
+		if loginSession, err := sessionManager.Session(ctx); err == nil {
+			id = loginSession.ID
+		}
+		return nil
+	})(ctx))
+	return id
+}
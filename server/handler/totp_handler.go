@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/qrcode"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+	"github.com/shangsuru/passkey-demo/repository"
+	"github.com/shangsuru/passkey-demo/totp"
+)
+
+// Pixels per module of the enrollment QR code
+const qrCodeScale = 6
+
+// TOTPController manages TOTP codes from an authenticator app as a second factor for password logins.
+type TOTPController struct {
+	UserRepository repository.UserRepository
+	SessionManager SessionManager
+	Limiter        ratelimit.Limiter
+	// The relying party name is shown as the issuer in authenticator apps
+	WebAuthnConfig config.WebAuthnConfig
+}
+
+type TOTPEnrollmentResponse struct {
+	Response
+	// Secret for typing it into the authenticator app, and the otpauth:// URI with the
+	// QR code of it for scanning it
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qr_code"`
+}
+
+// BeginEnrollTOTP generates a new secret for the signed-in user. It is only used once
+// FinishEnrollTOTP confirmed that the authenticator app produces valid codes for it.
+func (handler TOTPController) BeginEnrollTOTP() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if !user.HasPassword() {
+			return sendError(ctx, "A second factor is only needed for password logins.", http.StatusBadRequest)
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		uri := totp.URI(handler.WebAuthnConfig.RPDisplayName, user.Username, secret)
+		code, err := qrcode.Encode([]byte(uri))
+		if errors.Is(err, qrcode.ErrTooLong) {
+			return sendError(ctx, "The username is too long for an authenticator app.", http.StatusBadRequest)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		png, err := code.PNG(qrCodeScale)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		err = handler.UserRepository.SetPendingTOTPSecret(ctx.Request().Context(), user.ID, secret)
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "Two-factor authentication is already enabled.", http.StatusConflict)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return ctx.JSON(http.StatusOK, TOTPEnrollmentResponse{
+			Response: Response{Status: "ok"},
+			Secret:   secret,
+			URI:      uri,
+			QRCode:   "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		})
+	}
+}
+
+// FinishEnrollTOTP enables the pending secret after checking a code the authenticator app generated for it.
+func (handler TOTPController) FinishEnrollTOTP() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if user.TOTPEnabled() {
+			return sendError(ctx, "Two-factor authentication is already enabled.", http.StatusConflict)
+		}
+		if user.TOTPSecret == "" {
+			return sendError(ctx, "Two-factor authentication setup was not started.", http.StatusBadRequest)
+		}
+
+		counter, ok := totp.Validate(user.TOTPSecret, p.Code, time.Now(), 0)
+		if !ok {
+			return sendError(ctx, "Invalid code.", http.StatusBadRequest)
+		}
+
+		err = handler.UserRepository.EnableTOTP(ctx.Request().Context(), user.ID, user.TOTPSecret, counter)
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "Two-factor authentication setup was restarted.", http.StatusConflict)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.SessionManager.Rotate(ctx); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// DisableTOTP turns off the second factor, so the password alone signs the user in again.
+func (handler TOTPController) DisableTOTP() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		if err := handler.UserRepository.DisableTOTP(ctx.Request().Context(), userID); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		if err := handler.SessionManager.Rotate(ctx); err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return sendOK(ctx)
+	}
+}
+
+// LoginWithTOTP completes a password login that is waiting for the second factor. Wrong codes
+// count towards the lockout of the account like wrong passwords, and every code works only once.
+func (handler TOTPController) LoginWithTOTP() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		pending, err := handler.SessionManager.PendingSession(ctx)
+		if errors.Is(err, ErrSessionExpired) {
+			return sendError(ctx, "Login expired. Please enter your password again.", http.StatusUnauthorized)
+		}
+		if err != nil {
+			return sendError(ctx, "No login is waiting for a code.", http.StatusUnauthorized)
+		}
+
+		var p Params
+		if err := ctx.Bind(&p); err != nil {
+			return sendError(ctx, err.Error(), http.StatusBadRequest)
+		}
+
+		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), pending.UserID[:])
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		lockedFor, err := handler.Limiter.LockedFor(ctx.Request().Context(), user.Username)
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+		if lockedFor > 0 {
+			return sendLockedOut(ctx, lockedFor)
+		}
+
+		// Disabled in the meantime, so the password is all that is needed
+		if !user.TOTPEnabled() {
+			return handler.completeLogin(ctx, user.Username, pending)
+		}
+
+		counter, ok := totp.Validate(user.TOTPSecret, p.Code, time.Now(), user.TOTPLastCounter)
+		if !ok {
+			return handler.failLogin(ctx, user.Username)
+		}
+
+		err = handler.UserRepository.UseTOTPCounter(ctx.Request().Context(), user.ID, counter)
+		if errors.Is(err, repository.ErrTOTPCodeUsed) {
+			return handler.failLogin(ctx, user.Username)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
+
+		return handler.completeLogin(ctx, user.Username, pending)
+	}
+}
+
+func (handler TOTPController) completeLogin(ctx echo.Context, username string, pending *model.LoginSession) error {
+	if err := handler.Limiter.Unlock(ctx.Request().Context(), username); err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+
+	err := handler.SessionManager.Complete(ctx, pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sendError(ctx, "No login is waiting for a code.", http.StatusUnauthorized)
+	}
+	if err != nil {
+		return sendError(ctx, "Session could not be created", http.StatusInternalServerError)
+	}
+
+	return sendOK(ctx)
+}
+
+// failLogin counts a wrong code towards the lockout of the account.
+func (handler TOTPController) failLogin(ctx echo.Context, username string) error {
+	lockedFor, err := handler.Limiter.Fail(ctx.Request().Context(), username)
+	if err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+	if lockedFor > 0 {
+		return sendLockedOut(ctx, lockedFor)
+	}
+	return sendError(ctx, "Invalid code.", http.StatusUnauthorized)
+}
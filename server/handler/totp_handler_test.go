@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/totp"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTOTPController() TOTPController {
+	return TOTPController{
+		UserRepository: userRepository,
+		SessionManager: sessionManager,
+		Limiter:        limiter,
+		WebAuthnConfig: config.WebAuthnConfig{RPDisplayName: "Passkey Demo"},
+	}
+}
+
+// createTOTPUser inserts a password user with TOTP enabled and returns the secret.
+func createTOTPUser(t *testing.T, username string) (*model.User, string) {
+	passwordHash, err := argon2id.CreateHash("password123", argon2id.DefaultParams)
+	assert.NoError(t, err)
+	user, _ := createPasskeyUser(t, username, passwordHash, 0)
+
+	secret, err := totp.GenerateSecret()
+	assert.NoError(t, err)
+	assert.NoError(t, userRepository.SetPendingTOTPSecret(context.Background(), user.ID, secret))
+	assert.NoError(t, userRepository.EnableTOTP(context.Background(), user.ID, secret, 0))
+	return user, secret
+}
+
+func currentCode(t *testing.T, secret string) string {
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	assert.NoError(t, err)
+	return code
+}
+
+func TestTOTPController_Enroll(t *testing.T) {
+	totpController := newTOTPController()
+
+	request := func(t *testing.T, h echo.HandlerFunc, cookie *http.Cookie, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/api/account/totp", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(h)(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("passkey-only account", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "totp_passkey_only_user", "", 1)
+
+		rec := request(t, totpController.BeginEnrollTOTP(), loginAs(t, user.ID), "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("confirms the secret with a code", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "totp_enroll_user", "hash", 0)
+		cookie := loginAs(t, user.ID)
+
+		rec := request(t, totpController.BeginEnrollTOTP(), cookie, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var response TOTPEnrollmentResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		assert.Equal(t, totp.URI("Passkey Demo", "totp_enroll_user", response.Secret), response.URI)
+		assert.True(t, strings.HasPrefix(response.QRCode, "data:image/png;base64,"))
+
+		// Not required before it is confirmed
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.False(t, user.TOTPEnabled())
+
+		rec = request(t, totpController.FinishEnrollTOTP(), cookie, `{"code":"000000"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid code."}`, rec.Body.String())
+
+		rec = request(t, totpController.FinishEnrollTOTP(), cookie, `{"code":"`+currentCode(t, response.Secret)+`"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		user, err = userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.True(t, user.TOTPEnabled())
+
+		rec = request(t, totpController.BeginEnrollTOTP(), rec.Result().Cookies()[0], "")
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("long username", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "totp_long_user_"+strings.Repeat("a", 240), "hash", 0)
+
+		rec := request(t, totpController.BeginEnrollTOTP(), loginAs(t, user.ID), "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("username too long for a QR code", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "totp_too_long_user_"+strings.Repeat("a", 2400), "hash", 0)
+
+		rec := request(t, totpController.BeginEnrollTOTP(), loginAs(t, user.ID), "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.Empty(t, user.TOTPSecret)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		user, _ := createTOTPUser(t, "totp_disable_user")
+
+		rec := request(t, totpController.DisableTOTP(), loginAs(t, user.ID), "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.False(t, user.TOTPEnabled())
+	})
+}
+
+func TestTOTPController_LoginWithTOTP(t *testing.T) {
+	totpController := newTOTPController()
+
+	// loginWithPassword returns the cookie of the login waiting for the code.
+	loginWithPassword := func(t *testing.T, username string) *http.Cookie {
+		req := httptest.NewRequest(echo.POST, "/login", strings.NewReader(`{"username":"`+username+`", "password":"password123"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(passwordController.Login())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"status": "ok", "errorMessage":"", "second_factor":"totp"}`, rec.Body.String())
+		return rec.Result().Cookies()[0]
+	}
+
+	loginWithCode := func(t *testing.T, cookie *http.Cookie, code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/login/totp", strings.NewReader(`{"code":"`+code+`"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(totpController.LoginWithTOTP())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("the password alone does not sign in", func(t *testing.T) {
+		createTOTPUser(t, "totp_partial_user")
+
+		cookie := loginWithPassword(t, "totp_partial_user")
+		assert.Equal(t, uuid.Nil, findSessionID(t, cookie))
+	})
+
+	t.Run("the code completes the login once", func(t *testing.T) {
+		_, secret := createTOTPUser(t, "totp_login_user")
+		code := currentCode(t, secret)
+
+		cookie := loginWithPassword(t, "totp_login_user")
+		rec := loginWithCode(t, cookie, code)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEqual(t, uuid.Nil, findSessionID(t, rec.Result().Cookies()[0]))
+
+		// The pending login is used up
+		rec = loginWithCode(t, cookie, code)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+		// The code cannot be replayed in another login
+		rec = loginWithCode(t, loginWithPassword(t, "totp_login_user"), code)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Invalid code."}`, rec.Body.String())
+	})
+
+	t.Run("wrong codes lock the account", func(t *testing.T) {
+		_, secret := createTOTPUser(t, "totp_lockout_user")
+		cookie := loginWithPassword(t, "totp_lockout_user")
+
+		assert.Equal(t, http.StatusUnauthorized, loginWithCode(t, cookie, "000000").Code)
+		assert.Equal(t, http.StatusUnauthorized, loginWithCode(t, cookie, "111111").Code)
+		assert.Equal(t, http.StatusTooManyRequests, loginWithCode(t, cookie, "222222").Code)
+		assert.Equal(t, http.StatusTooManyRequests, loginWithCode(t, cookie, currentCode(t, secret)).Code)
+	})
+
+	t.Run("without a pending login", func(t *testing.T) {
+		user, _ := createTOTPUser(t, "totp_signed_in_user")
+
+		rec := loginWithCode(t, loginAs(t, user.ID), "000000")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"No login is waiting for a code."}`, rec.Body.String())
+	})
+}
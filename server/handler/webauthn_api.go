@@ -1,16 +1,15 @@
 package handler
 
 import (
-	"os"
-
 	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/shangsuru/passkey-demo/config"
 )
 
-func NewWebAuthnAPI() (*webauthn.WebAuthn, error) {
+func NewWebAuthnAPI(cfg config.WebAuthnConfig) (*webauthn.WebAuthn, error) {
 	webAuthnAPI, err := webauthn.New(&webauthn.Config{
-		RPDisplayName: os.Getenv("RP_DISPLAY_NAME"),
-		RPID:          os.Getenv("RP_ID"),
-		RPOrigins:     []string{os.Getenv("RP_ORIGIN")},
+		RPDisplayName: cfg.RPDisplayName,
+		RPID:          cfg.RPID,
+		RPOrigins:     cfg.RPOrigins,
 	})
 	return webAuthnAPI, err
 }
@@ -1,22 +1,44 @@
 package handler
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
 	"net/http"
+	"time"
 
-	"github.com/alexedwards/argon2id"
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/gommon/random"
+	"github.com/shangsuru/passkey-demo/attestation"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/provider"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+	"github.com/shangsuru/passkey-demo/recovery"
 	"github.com/shangsuru/passkey-demo/repository"
 )
 
+// Passkeys must be discoverable and user verifying, so they can replace both username and password.
+var registrationAuthenticatorSelection = protocol.AuthenticatorSelection{
+	RequireResidentKey: protocol.ResidentKeyRequired(),
+	ResidentKey:        protocol.ResidentKeyRequirementRequired,
+	UserVerification:   protocol.VerificationRequired,
+}
+
 type WebAuthnController struct {
-	UserRepository  repository.UserRepository
-	WebAuthnAPI     *webauthn.WebAuthn
-	WebAuthnSession WebAuthnSession
+	UserRepository    repository.UserRepository
+	WebAuthnAPI       *webauthn.WebAuthn
+	WebAuthnSession   WebAuthnSession
+	SessionManager    SessionManager
+	Limiter           ratelimit.Limiter
+	AntiEnumeration   AntiEnumeration
+	AttestationPolicy attestation.Policy
+	Providers         provider.Registry
 }
 
+// BeginRegistration starts the sign-up of a new passkey-only account. The account is only
+// reserved as a pending registration until FinishRegistration creates it.
 func (handler WebAuthnController) BeginRegistration() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		var p Params
@@ -28,41 +50,35 @@ func (handler WebAuthnController) BeginRegistration() echo.HandlerFunc {
 			return sendError(ctx, "Empty username", http.StatusBadRequest)
 		}
 
-		_, err := handler.UserRepository.FindUserByUsername(ctx.Request().Context(), username)
-		if err == nil {
-			return sendError(ctx, "An account with that username already exists.", http.StatusConflict)
+		// With anti-enumeration, a taken username is only rejected once the ceremony completes
+		if !handler.AntiEnumeration.Enabled {
+			_, err := handler.UserRepository.FindUserByUsername(ctx.Request().Context(), username)
+			if err == nil {
+				return sendError(ctx, "An account with that username already exists.", http.StatusConflict)
+			}
 		}
 
-		// create a random password to fulfill not null constraint in user_repository.go
-		passwordHash, err := argon2id.CreateHash(random.String(20), argon2id.DefaultParams)
-		if err != nil {
-			return sendError(ctx, "Internal server error", http.StatusInternalServerError)
+		// A client that starts over, e.g. after cancelling the authenticator prompt, releases the
+		// username it reserved before instead of being locked out by it
+		if previous, err := handler.WebAuthnSession.Get(ctx, CeremonyRegistration); err == nil {
+			handler.releasePendingRegistration(ctx, previous.UserID)
 		}
 
-		user, err := handler.UserRepository.CreateUser(ctx.Request().Context(), username, passwordHash)
+		pending, err := handler.UserRepository.CreatePendingRegistration(ctx.Request().Context(), username, time.Now().Add(webauthnSessionDuration))
+		if errors.Is(err, repository.ErrRegistrationPending) {
+			return handler.AntiEnumeration.rejectSignUp(ctx, "passkey", username, err)
+		}
 		if err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
-		authSelect := protocol.AuthenticatorSelection{
-			RequireResidentKey: protocol.ResidentKeyRequired(),
-			ResidentKey:        protocol.ResidentKeyRequirementRequired,
-			UserVerification:   protocol.VerificationRequired,
-		}
-
-		options, sessionData, err := handler.WebAuthnAPI.BeginRegistration(
-			user,
-			webauthn.WithAuthenticatorSelection(authSelect),
-			webauthn.WithExclusions(user.CredentialExcludeList()),
-		)
+		options, sessionData, err := handler.beginRegistration(pending.User())
 		if err != nil {
-			_ = handler.UserRepository.DeleteUser(ctx.Request().Context(), user)
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
-		err = handler.WebAuthnSession.Create(ctx, "registration", sessionData)
+		err = handler.WebAuthnSession.Create(ctx, CeremonyRegistration, sessionData)
 		if err != nil {
-			_ = handler.UserRepository.DeleteUser(ctx.Request().Context(), user)
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
@@ -72,41 +88,189 @@ func (handler WebAuthnController) BeginRegistration() echo.HandlerFunc {
 
 func (handler WebAuthnController) FinishRegistration() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		sessionID, sessionData, err := handler.WebAuthnSession.Get(ctx, "registration")
+		sessionData, err := handler.WebAuthnSession.Get(ctx, CeremonyRegistration)
 		if err != nil {
 			return sendError(ctx, err.Error(), http.StatusBadRequest)
 		}
 
-		user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), sessionData.UserID)
+		pending, err := handler.UserRepository.FindPendingRegistration(ctx.Request().Context(), sessionData.UserID)
 		if err != nil {
-			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+			return sendError(ctx, "Registration expired. Please try again.", http.StatusBadRequest)
+		}
+		// The challenge is used up, so a failed attempt must not keep the username reserved.
+		// After success, the reservation is already gone.
+		defer handler.releasePendingRegistration(ctx, sessionData.UserID)
+
+		credential, attestationObject, err := handler.createCredential(ctx, pending.User(), sessionData)
+		if errors.Is(err, attestation.ErrRejected) {
+			ctx.Logger().Infof("passkey sign-up for %q failed: %v", pending.Username, err)
+			return sendError(ctx, "This authenticator is not allowed.", http.StatusBadRequest)
 		}
-
-		credential, err := handler.WebAuthnAPI.FinishRegistration(user, *sessionData, ctx.Request())
 		if err != nil {
-			_ = handler.UserRepository.DeleteUser(ctx.Request().Context(), user)
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
 		if !credential.Flags.UserPresent || !credential.Flags.UserVerified {
-			_ = handler.UserRepository.DeleteUser(ctx.Request().Context(), user)
 			return sendError(ctx, "User not present or not verified.", http.StatusBadRequest)
 		}
 
-		if err := handler.UserRepository.AddWebauthnCredential(ctx.Request().Context(), user.ID, credential); err != nil {
-			_ = handler.UserRepository.DeleteUser(ctx.Request().Context(), user)
+		// The recovery codes are the way back in if the passkey is lost, and are only shown now
+		recoveryCodes, recoveryCodeHashes, err := recovery.Generate()
+		if err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
-		_ = handler.WebAuthnSession.Delete(ctx.Request().Context(), sessionID)
+		// Accounts created with a passkey have no password
+		user, err := handler.UserRepository.CompletePendingRegistration(ctx.Request().Context(), pending, "", credential, attestationObject, handler.credentialName(ctx, credential), recoveryCodeHashes)
+		if errors.Is(err, repository.ErrUsernameTaken) {
+			return handler.AntiEnumeration.rejectSignUp(ctx, "passkey", pending.Username, err)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return sendError(ctx, "Registration expired. Please try again.", http.StatusBadRequest)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
 
-		if err = createSession(ctx, user.ID.String()); err != nil {
-			_ = handler.UserRepository.DeleteUser(ctx.Request().Context(), user)
+		if err = handler.SessionManager.Create(ctx, user.ID, AuthMethodPasskey, false); err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
-		return sendOK(ctx)
+		return ctx.JSON(http.StatusOK, RecoveryCodesResponse{
+			Response:      Response{Status: "ok"},
+			RecoveryCodes: recoveryCodes,
+		})
+	}
+}
+
+// releasePendingRegistration deletes the pending registration with the given WebAuthn user handle.
+// Failures are only logged, as the reservation expires anyway.
+func (handler WebAuthnController) releasePendingRegistration(ctx echo.Context, rawUserID []byte) {
+	id, err := uuid.FromBytes(rawUserID)
+	if err != nil {
+		return
+	}
+	if err := handler.UserRepository.DeletePendingRegistration(ctx.Request().Context(), id); err != nil {
+		ctx.Logger().Errorf("failed to release pending registration %s: %v", id, err)
+	}
+}
+
+// BeginAddPasskey starts the registration of an additional passkey for the signed-in user.
+func (handler WebAuthnController) BeginAddPasskey() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		return handler.beginAddPasskey(ctx, userID, CeremonyAddPasskey)
+	}
+}
+
+// FinishAddPasskey stores the new passkey on the signed-in user. Unlike FinishRegistration,
+// the account already existed before the ceremony and is never deleted on failure.
+func (handler WebAuthnController) FinishAddPasskey() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		userID, err := handler.SessionManager.UserID(ctx)
+		if err != nil {
+			return sendError(ctx, "Not logged in.", http.StatusUnauthorized)
+		}
+
+		return handler.finishAddPasskey(ctx, userID, CeremonyAddPasskey, func() error {
+			return sendOK(ctx)
+		})
+	}
+}
+
+// BeginRecoverPasskey starts the registration of the passkey that a login with a recovery code requires.
+func (handler WebAuthnController) BeginRecoverPasskey() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		recovering, err := handler.SessionManager.RecoverySession(ctx)
+		if err != nil {
+			return sendError(ctx, "No recovery in progress.", http.StatusUnauthorized)
+		}
+
+		return handler.beginAddPasskey(ctx, recovering.UserID, CeremonyRecoverPasskey)
+	}
+}
+
+// FinishRecoverPasskey stores the new passkey and completes the login with the recovery code.
+func (handler WebAuthnController) FinishRecoverPasskey() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		recovering, err := handler.SessionManager.RecoverySession(ctx)
+		if err != nil {
+			return sendError(ctx, "No recovery in progress.", http.StatusUnauthorized)
+		}
+
+		return handler.finishAddPasskey(ctx, recovering.UserID, CeremonyRecoverPasskey, func() error {
+			err := handler.SessionManager.Complete(ctx, recovering)
+			if errors.Is(err, sql.ErrNoRows) {
+				return sendError(ctx, "No recovery in progress.", http.StatusUnauthorized)
+			}
+			if err != nil {
+				return sendError(ctx, err.Error(), http.StatusInternalServerError)
+			}
+
+			handler.unlockAccount(ctx, recovering.UserID)
+
+			return sendOK(ctx)
+		})
+	}
+}
+
+func (handler WebAuthnController) beginAddPasskey(ctx echo.Context, userID uuid.UUID, ceremony Ceremony) error {
+	user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+	if err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+
+	options, sessionData, err := handler.beginRegistration(user)
+	if err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+
+	if err := handler.WebAuthnSession.Create(ctx, ceremony, sessionData); err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+
+	return ctx.JSON(http.StatusOK, options)
+}
+
+// finishAddPasskey stores the passkey registered in the ceremony on the user. The response
+// after success is left to stored.
+func (handler WebAuthnController) finishAddPasskey(ctx echo.Context, userID uuid.UUID, ceremony Ceremony, stored func() error) error {
+	sessionData, err := handler.WebAuthnSession.Get(ctx, ceremony)
+	if err != nil {
+		return sendError(ctx, err.Error(), http.StatusBadRequest)
+	}
+
+	if !bytes.Equal(sessionData.UserID, userID[:]) {
+		return sendError(ctx, "Registration was started by another account.", http.StatusBadRequest)
+	}
+
+	user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), sessionData.UserID)
+	if err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+
+	credential, attestationObject, err := handler.createCredential(ctx, user, sessionData)
+	if errors.Is(err, attestation.ErrRejected) {
+		ctx.Logger().Infof("adding passkey for %q failed: %v", user.Username, err)
+		return sendError(ctx, "This authenticator is not allowed.", http.StatusBadRequest)
+	}
+	if err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
+	}
+
+	if !credential.Flags.UserPresent || !credential.Flags.UserVerified {
+		return sendError(ctx, "User not present or not verified.", http.StatusBadRequest)
+	}
+
+	if err := handler.UserRepository.AddWebauthnCredential(ctx.Request().Context(), user.ID, credential, attestationObject, handler.credentialName(ctx, credential)); err != nil {
+		return sendError(ctx, err.Error(), http.StatusInternalServerError)
 	}
+
+	return stored()
 }
 
 func (handler WebAuthnController) BeginLogin() echo.HandlerFunc {
@@ -116,7 +280,7 @@ func (handler WebAuthnController) BeginLogin() echo.HandlerFunc {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
-		if err := handler.WebAuthnSession.Create(ctx, "login", sessionData); err != nil {
+		if err := handler.WebAuthnSession.Create(ctx, CeremonyLogin, sessionData); err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
@@ -124,9 +288,11 @@ func (handler WebAuthnController) BeginLogin() echo.HandlerFunc {
 	}
 }
 
+// FinishLogin signs the user in. The body is the assertion, so "remember me" is passed as
+// the remember_me query parameter.
 func (handler WebAuthnController) FinishLogin() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		sessionID, sessionData, err := handler.WebAuthnSession.Get(ctx, "login")
+		sessionData, err := handler.WebAuthnSession.Get(ctx, CeremonyLogin)
 		if err != nil {
 			return sendError(ctx, err.Error(), http.StatusBadRequest)
 		}
@@ -144,17 +310,25 @@ func (handler WebAuthnController) FinishLogin() echo.HandlerFunc {
 			return sendError(ctx, "Authenticator is cloned.", http.StatusBadRequest)
 		}
 
-		_ = handler.WebAuthnSession.Delete(ctx.Request().Context(), sessionID)
+		err = handler.UserRepository.UpdateWebauthnCredentialAfterLogin(ctx.Request().Context(), credential)
+		if errors.Is(err, repository.ErrStaleSignCount) {
+			return sendError(ctx, "Authenticator is cloned.", http.StatusBadRequest)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
 
 		userID, err := handler.UserRepository.FindUserIDByCredentialID(ctx.Request().Context(), credential.ID)
 		if err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
-		if err = createSession(ctx, (*userID).String()); err != nil {
+		if err = handler.SessionManager.Create(ctx, *userID, AuthMethodPasskey, rememberMe(ctx)); err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
+		handler.unlockAccount(ctx, *userID)
+
 		return sendOK(ctx)
 	}
 }
@@ -166,7 +340,7 @@ func (handler WebAuthnController) BeginDiscoverableLogin() echo.HandlerFunc {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
-		if err := handler.WebAuthnSession.Create(ctx, "login", sessionData); err != nil {
+		if err := handler.WebAuthnSession.Create(ctx, CeremonyDiscoverableLogin, sessionData); err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
@@ -174,9 +348,10 @@ func (handler WebAuthnController) BeginDiscoverableLogin() echo.HandlerFunc {
 	}
 }
 
+// FinishDiscoverableLogin signs the user in like FinishLogin.
 func (handler WebAuthnController) FinishDiscoverableLogin() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		sessionID, sessionData, err := handler.WebAuthnSession.Get(ctx, "login")
+		sessionData, err := handler.WebAuthnSession.Get(ctx, CeremonyDiscoverableLogin)
 		if err != nil {
 			return sendError(ctx, err.Error(), http.StatusBadRequest)
 		}
@@ -194,21 +369,80 @@ func (handler WebAuthnController) FinishDiscoverableLogin() echo.HandlerFunc {
 			return sendError(ctx, "Authenticator is cloned.", http.StatusBadRequest)
 		}
 
-		_ = handler.WebAuthnSession.Delete(ctx.Request().Context(), sessionID)
+		err = handler.UserRepository.UpdateWebauthnCredentialAfterLogin(ctx.Request().Context(), credential)
+		if errors.Is(err, repository.ErrStaleSignCount) {
+			return sendError(ctx, "Authenticator is cloned.", http.StatusBadRequest)
+		}
+		if err != nil {
+			return sendError(ctx, err.Error(), http.StatusInternalServerError)
+		}
 
 		userID, err := handler.UserRepository.FindUserIDByCredentialID(ctx.Request().Context(), credential.ID)
 		if err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
-		if err = createSession(ctx, (*userID).String()); err != nil {
+		if err = handler.SessionManager.Create(ctx, *userID, AuthMethodPasskey, rememberMe(ctx)); err != nil {
 			return sendError(ctx, err.Error(), http.StatusInternalServerError)
 		}
 
+		handler.unlockAccount(ctx, *userID)
+
 		return sendOK(ctx)
 	}
 }
 
+// unlockAccount lifts a password lockout after the user proved their identity with a passkey.
+func (handler WebAuthnController) unlockAccount(ctx echo.Context, userID uuid.UUID) {
+	user, err := handler.UserRepository.FindUserByID(ctx.Request().Context(), userID[:])
+	if err == nil {
+		err = handler.Limiter.Unlock(ctx.Request().Context(), user.Username)
+	}
+	if err != nil {
+		ctx.Logger().Errorf("failed to unlock account %s: %v", userID, err)
+	}
+}
+
+// rememberMe reports whether the user asked to stay signed in.
+func rememberMe(ctx echo.Context) bool {
+	return ctx.QueryParam("remember_me") == "true"
+}
+
+func (handler WebAuthnController) beginRegistration(user *model.User) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	return handler.WebAuthnAPI.BeginRegistration(
+		user,
+		webauthn.WithAuthenticatorSelection(registrationAuthenticatorSelection),
+		webauthn.WithExclusions(user.CredentialExcludeList()),
+		webauthn.WithConveyancePreference(handler.AttestationPolicy.Conveyance),
+	)
+}
+
+// credentialName names a new passkey after its provider or the device it was registered on.
+func (handler WebAuthnController) credentialName(ctx echo.Context, credential *webauthn.Credential) string {
+	aaguid, _ := uuid.FromBytes(credential.Authenticator.AAGUID)
+	return handler.Providers.CredentialName(aaguid, ctx.Request().UserAgent())
+}
+
+// createCredential verifies the registration response and returns the new credential together
+// with the raw attestation object, which the attestation policy can be checked against again.
+func (handler WebAuthnController) createCredential(ctx echo.Context, user webauthn.User, sessionData *webauthn.SessionData) (*webauthn.Credential, []byte, error) {
+	parsedResponse, err := protocol.ParseCredentialCreationResponse(ctx.Request())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credential, err := handler.WebAuthnAPI.CreateCredential(user, *sessionData, parsedResponse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := handler.AttestationPolicy.Verify(parsedResponse.Response.AttestationObject); err != nil {
+		return nil, nil, err
+	}
+
+	return credential, parsedResponse.Raw.AttestationResponse.AttestationObject, nil
+}
+
 func (handler WebAuthnController) getCredentialAssertion(ctx echo.Context) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
 	var p Params
 	if err := ctx.Bind(&p); err != nil {
@@ -216,7 +450,18 @@ func (handler WebAuthnController) getCredentialAssertion(ctx echo.Context) (*pro
 	}
 
 	user, err := handler.UserRepository.FindUserByUsername(ctx.Request().Context(), p.Username)
-	if err != nil {
+	if handler.AntiEnumeration.Enabled {
+		// Options for a made-up passkey look like those of an existing user, and the
+		// ceremony fails in FinishLogin just like with a wrong passkey
+		switch {
+		case err != nil:
+			ctx.Logger().Infof("passkey login for %q: unknown username, sending decoy options", p.Username)
+			user = handler.AntiEnumeration.decoyUser(p.Username)
+		case len(user.WebauthnCredentials) == 0:
+			ctx.Logger().Infof("passkey login for %q: account has no passkey, sending decoy options", p.Username)
+			user = handler.AntiEnumeration.decoyUser(p.Username)
+		}
+	} else if err != nil {
 		return nil, nil, err
 	}
 
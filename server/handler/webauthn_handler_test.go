@@ -0,0 +1,343 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/sessionstore"
+	"github.com/stretchr/testify/assert"
+)
+
+func newWebAuthnController(t *testing.T) WebAuthnController {
+	webAuthnAPI, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: "PasskeyDemo",
+		RPID:          "localhost",
+		RPOrigins:     []string{"http://localhost:9044"},
+	})
+	assert.NoError(t, err)
+
+	store := sessionstore.NewMemoryStore(time.Hour)
+	t.Cleanup(store.Close)
+
+	return WebAuthnController{
+		UserRepository:  userRepository,
+		WebAuthnAPI:     webAuthnAPI,
+		WebAuthnSession: NewWebAuthnSession(store, Cookies{}),
+		SessionManager:  sessionManager,
+		Limiter:         limiter,
+	}
+}
+
+func TestWebAuthnController_BeginRegistration(t *testing.T) {
+	webAuthnController := newWebAuthnController(t)
+
+	t.Run("invalid username", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/register/begin", strings.NewReader(`{"username":""}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, webAuthnController.BeginRegistration()(ctx))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Empty username"}`, rec.Body.String())
+	})
+
+	t.Run("account already exists", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/register/begin", strings.NewReader(`{"username":"existing_user"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, webAuthnController.BeginRegistration()(ctx))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"An account with that username already exists."}`, rec.Body.String())
+	})
+
+	t.Run("successful begin", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/register/begin", strings.NewReader(`{"username":"registering_user"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, webAuthnController.BeginRegistration()(ctx))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"challenge"`)
+		assert.Equal(t, "registration", rec.Result().Cookies()[0].Name)
+
+		// The account is not created before the ceremony completes
+		_, err := userRepository.FindUserByUsername(context.Background(), "registering_user")
+		assert.Error(t, err)
+	})
+
+	t.Run("username reserved by a registration in progress", func(t *testing.T) {
+		begin := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(echo.POST, "/register/begin", strings.NewReader(`{"username":"reserved_user"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			assert.NoError(t, webAuthnController.BeginRegistration()(e.NewContext(req, rec)))
+			return rec
+		}
+		assert.Equal(t, http.StatusOK, begin().Code)
+
+		rec := begin()
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"A registration for this username is already in progress."}`, rec.Body.String())
+
+		req := httptest.NewRequest(echo.POST, "/signup", strings.NewReader(`{"username":"reserved_user", "password":"password123"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec = httptest.NewRecorder()
+		assert.NoError(t, withSession(passwordController.SignUp())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+	t.Run("the same client can start over", func(t *testing.T) {
+		begin := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(echo.POST, "/register/begin", strings.NewReader(`{"username":"restarting_user"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if cookie != nil {
+				req.AddCookie(cookie)
+			}
+			rec := httptest.NewRecorder()
+			assert.NoError(t, webAuthnController.BeginRegistration()(e.NewContext(req, rec)))
+			return rec
+		}
+		rec := begin(nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec = begin(registrationCookie(rec))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		// Others still cannot take the username
+		assert.Equal(t, http.StatusConflict, begin(nil).Code)
+	})
+}
+
+// registrationCookie returns the registration ceremony cookie that rec sets last.
+func registrationCookie(rec *httptest.ResponseRecorder) *http.Cookie {
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == string(CeremonyRegistration) {
+			cookie = c
+		}
+	}
+	return cookie
+}
+
+func TestWebAuthnController_FinishRegistration(t *testing.T) {
+	webAuthnController := newWebAuthnController(t)
+
+	t.Run("no registration in progress", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/register/finish", strings.NewReader(`{}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, webAuthnController.FinishRegistration()(ctx))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("invalid attestation", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/register/begin", strings.NewReader(`{"username":"failing_user"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, webAuthnController.BeginRegistration()(e.NewContext(req, rec)))
+		cookie := rec.Result().Cookies()[0]
+
+		req = httptest.NewRequest(echo.POST, "/register/finish", strings.NewReader(`{}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(cookie)
+		rec = httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, webAuthnController.FinishRegistration()(ctx))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+		// No account is left behind
+		_, err := userRepository.FindUserByUsername(context.Background(), "failing_user")
+		assert.Error(t, err)
+	})
+
+	t.Run("a failed ceremony releases the username", func(t *testing.T) {
+		begin := func() *httptest.ResponseRecorder {
+			req := httptest.NewRequest(echo.POST, "/register/begin", strings.NewReader(`{"username":"retrying_user"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			assert.NoError(t, webAuthnController.BeginRegistration()(e.NewContext(req, rec)))
+			return rec
+		}
+		rec := begin()
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		req := httptest.NewRequest(echo.POST, "/register/finish", strings.NewReader(`{}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(registrationCookie(rec))
+		rec = httptest.NewRecorder()
+		assert.NoError(t, webAuthnController.FinishRegistration()(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+		assert.Equal(t, http.StatusOK, begin().Code)
+	})
+}
+
+func TestWebAuthnController_BeginLogin(t *testing.T) {
+	webAuthnController := newWebAuthnController(t)
+	createPasskeyUser(t, "login_user", "", 1)
+
+	req := httptest.NewRequest(echo.POST, "/login/begin", strings.NewReader(`{"username":"login_user"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	ctx := e.NewContext(req, rec)
+
+	assert.NoError(t, webAuthnController.BeginLogin()(ctx))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"allowCredentials"`)
+	assert.Equal(t, "login", rec.Result().Cookies()[0].Name)
+}
+
+func TestWebAuthnController_BeginAddPasskey(t *testing.T) {
+	webAuthnController := newWebAuthnController(t)
+
+	t.Run("not logged in", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/api/passkeys/register/begin", nil)
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(webAuthnController.BeginAddPasskey())(ctx))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("excludes existing passkeys", func(t *testing.T) {
+		createPasskeyUser(t, "add_passkey_user", "hash", 1)
+		user, err := userRepository.FindUserByUsername(context.Background(), "add_passkey_user")
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(echo.POST, "/api/passkeys/register/begin", nil)
+		req.AddCookie(loginAs(t, user.ID))
+		rec := httptest.NewRecorder()
+		ctx := e.NewContext(req, rec)
+
+		assert.NoError(t, withSession(webAuthnController.BeginAddPasskey())(ctx))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		credentialID := base64.RawURLEncoding.EncodeToString(user.WebauthnCredentials[0].CredentialID)
+		assert.Contains(t, rec.Body.String(), credentialID)
+	})
+}
+
+func TestWebAuthnController_FinishAddPasskey(t *testing.T) {
+	webAuthnController := newWebAuthnController(t)
+
+	beginAddPasskey := func(t *testing.T, session *http.Cookie) *http.Cookie {
+		req := httptest.NewRequest(echo.POST, "/api/passkeys/register/begin", nil)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(webAuthnController.BeginAddPasskey())(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		for _, cookie := range rec.Result().Cookies() {
+			if cookie.Name == string(CeremonyAddPasskey) {
+				return cookie
+			}
+		}
+		t.Fatal("no add_passkey cookie")
+		return nil
+	}
+
+	finishAddPasskey := func(t *testing.T, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/api/passkeys/register/finish", strings.NewReader(`{}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(webAuthnController.FinishAddPasskey())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("not logged in", func(t *testing.T) {
+		rec := finishAddPasskey(t)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("no registration in progress", func(t *testing.T) {
+		user, _ := createPasskeyUser(t, "finish_add_passkey_idle_user", "", 1)
+
+		rec := finishAddPasskey(t, loginAs(t, user.ID))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("registration of another account", func(t *testing.T) {
+		alice, _ := createPasskeyUser(t, "finish_add_passkey_alice", "", 1)
+		bob, _ := createPasskeyUser(t, "finish_add_passkey_bob", "", 1)
+		ceremony := beginAddPasskey(t, loginAs(t, alice.ID))
+
+		rec := finishAddPasskey(t, loginAs(t, bob.ID), ceremony)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Registration was started by another account."}`, rec.Body.String())
+	})
+
+	t.Run("a failed ceremony keeps the account and its passkeys", func(t *testing.T) {
+		user, credentialIDs := createPasskeyUser(t, "finish_add_passkey_failing_user", "", 2)
+		session := loginAs(t, user.ID)
+		ceremony := beginAddPasskey(t, session)
+
+		rec := finishAddPasskey(t, session, ceremony)
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+		user, err := userRepository.FindUserByID(context.Background(), user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.Len(t, user.WebauthnCredentials, len(credentialIDs))
+		assert.NotEqual(t, uuid.Nil, findSessionID(t, session))
+	})
+}
+
+func TestWebAuthnController_FinishDiscoverableLogin(t *testing.T) {
+	webAuthnController := newWebAuthnController(t)
+	createPasskeyUser(t, "discoverable_login_user", "", 1)
+
+	beginLogin := func(t *testing.T) *http.Cookie {
+		req := httptest.NewRequest(echo.POST, "/login/begin", strings.NewReader(`{"username":"discoverable_login_user"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, webAuthnController.BeginLogin()(e.NewContext(req, rec)))
+		return rec.Result().Cookies()[0]
+	}
+
+	finishDiscoverableLogin := func(t *testing.T, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/discoverable_login/finish", strings.NewReader(`{}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(webAuthnController.FinishDiscoverableLogin())(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("session of another ceremony", func(t *testing.T) {
+		cookie := beginLogin(t)
+		cookie.Name = string(CeremonyDiscoverableLogin)
+
+		rec := finishDiscoverableLogin(t, cookie)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"session belongs to a different ceremony"}`, rec.Body.String())
+	})
+
+	t.Run("challenge can only be used once", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/discoverable_login/begin", nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, webAuthnController.BeginDiscoverableLogin()(e.NewContext(req, rec)))
+		cookie := rec.Result().Cookies()[0]
+		assert.Equal(t, string(CeremonyDiscoverableLogin), cookie.Name)
+
+		rec = finishDiscoverableLogin(t, cookie)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = finishDiscoverableLogin(t, cookie)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "session not found")
+	})
+}
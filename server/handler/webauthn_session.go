@@ -1,80 +1,98 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/redis/go-redis/v9"
+	"github.com/shangsuru/passkey-demo/sessionstore"
 )
 
 const webauthnSessionDuration = 5 * time.Minute
 
+// Ceremony identifies the WebAuthn ceremony a session was created for.
+// It doubles as the name of the cookie that references the session, prefixed like the session cookie.
+type Ceremony string
+
+const (
+	CeremonyRegistration      Ceremony = "registration"
+	CeremonyLogin             Ceremony = "login"
+	CeremonyDiscoverableLogin Ceremony = "discoverable_login"
+	CeremonyAddPasskey        Ceremony = "add_passkey"
+	CeremonyRecoverPasskey    Ceremony = "recover_passkey"
+	CeremonyDeleteAccount     Ceremony = "delete_account"
+	CeremonyReauth            Ceremony = "reauth"
+)
+
+// ErrCeremonyMismatch is returned when a session is presented to a different ceremony
+// than the one it was created for.
+var ErrCeremonyMismatch = errors.New("session belongs to a different ceremony")
+
 type WebAuthnSession struct {
-	redisClient *redis.Client
+	store   sessionstore.SessionStore
+	cookies Cookies
 }
 
-func NewWebAuthnSession() WebAuthnSession {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     "localhost:16379",
-		Password: "",
-		DB:       0,
-	})
+// storedSession is what gets persisted in the session store.
+type storedSession struct {
+	Ceremony Ceremony              `json:"ceremony"`
+	Data     *webauthn.SessionData `json:"data"`
+}
 
+func NewWebAuthnSession(store sessionstore.SessionStore, cookies Cookies) WebAuthnSession {
 	return WebAuthnSession{
-		redisClient: redisClient,
+		store:   store,
+		cookies: cookies,
 	}
 }
 
-func (ws *WebAuthnSession) Get(ctx echo.Context, sessionName string) (string, *webauthn.SessionData, error) {
-	cookie, err := ctx.Cookie(sessionName)
+// Get returns the session data of the given ceremony and removes it from the store,
+// so that every challenge can only be answered once.
+func (ws *WebAuthnSession) Get(ctx echo.Context, ceremony Ceremony) (*webauthn.SessionData, error) {
+	cookie, err := ctx.Cookie(ws.cookies.Name(string(ceremony)))
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to get session cookie: %v", err)
+		return nil, fmt.Errorf("failed to get session cookie: %v", err)
 	}
-	id := cookie.Value
 
-	bytes, err := ws.redisClient.Get(ctx.Request().Context(), id).Bytes()
+	// The session is gone after this call, so the cookie is no longer needed either
+	ctx.SetCookie(ws.cookies.New(string(ceremony), "", -1))
+
+	bytes, err := ws.store.GetAndDelete(ctx.Request().Context(), cookie.Value)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to get session: %v", err)
+		return nil, fmt.Errorf("failed to get session: %v", err)
+	}
+
+	var session storedSession
+	if err := json.Unmarshal(bytes, &session); err != nil {
+		return nil, fmt.Errorf("failed to decode session data: %v", err)
 	}
 
-	var data *webauthn.SessionData
-	if err := json.Unmarshal(bytes, &data); err != nil {
-		return "", nil, fmt.Errorf("failed to decode session data: %v", err)
+	if session.Ceremony != ceremony || session.Data == nil {
+		return nil, ErrCeremonyMismatch
 	}
 
-	return id, data, nil
+	return session.Data, nil
 }
 
-func (ws *WebAuthnSession) Create(ctx echo.Context, sessionName string, data *webauthn.SessionData) error {
-	bytes, err := json.Marshal(data)
+func (ws *WebAuthnSession) Create(ctx echo.Context, ceremony Ceremony, data *webauthn.SessionData) error {
+	bytes, err := json.Marshal(storedSession{
+		Ceremony: ceremony,
+		Data:     data,
+	})
 	if err != nil {
 		return fmt.Errorf("failed to encode session data: %v", err)
 	}
 
 	id := uuid.New().String()
-	if err := ws.redisClient.Set(ctx.Request().Context(), id, bytes, webauthnSessionDuration).Err(); err != nil {
+	if err := ws.store.Create(ctx.Request().Context(), id, bytes, webauthnSessionDuration); err != nil {
 		return fmt.Errorf("failed to save session: %v", err)
 	}
 
-	ctx.SetCookie(&http.Cookie{
-		Name:  sessionName,
-		Value: id,
-		Path:  "/",
-	})
-
-	return nil
-}
-
-func (ws *WebAuthnSession) Delete(ctx context.Context, id string) error {
-	if err := ws.redisClient.Del(ctx, id).Err(); err != nil {
-		return fmt.Errorf("failed to delete session: %v", err)
-	}
+	ctx.SetCookie(ws.cookies.New(string(ceremony), id, int(webauthnSessionDuration.Seconds())))
 
 	return nil
 }
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+type WellKnownController struct {
+	WebAuthnConfig config.WebAuthnConfig
+}
+
+type RelatedOriginsResponse struct {
+	Origins []string `json:"origins"`
+}
+
+// RelatedOrigins lists the websites that may use passkeys of this relying party, see
+// https://w3c.github.io/webauthn/#sctn-related-origins.
+func (handler WellKnownController) RelatedOrigins() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		origins := handler.WebAuthnConfig.WebOrigins()
+		if origins == nil {
+			origins = []string{}
+		}
+
+		return ctx.JSON(http.StatusOK, RelatedOriginsResponse{Origins: origins})
+	}
+}
@@ -0,0 +1,24 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWellKnownController_RelatedOrigins(t *testing.T) {
+	wellKnownController := WellKnownController{WebAuthnConfig: config.WebAuthnConfig{
+		RPOrigins: []string{"https://example.com", "android:apk-key-hash:abc", "https://example.org"},
+	}}
+
+	req := httptest.NewRequest(echo.GET, "/.well-known/webauthn", nil)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(t, wellKnownController.RelatedOrigins()(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"origins":["https://example.com","https://example.org"]}`, rec.Body.String())
+}
@@ -0,0 +1,113 @@
+// Package mailer sends the emails of the server, like links to verify an email address. Besides
+// SMTP, emails can go to an outbox on stdout or in a directory, so no mail service is needed
+// to try the flows locally.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+// ErrInvalidHeader is returned for a recipient or subject that would break out of its header.
+var ErrInvalidHeader = errors.New("header must not contain line breaks")
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the backend selected by the mail.backend setting: "stdout", "file" or "smtp".
+func New(cfg config.MailConfig) (Mailer, error) {
+	switch backend := cfg.Backend; backend {
+	case "stdout":
+		return NewWriterOutbox(cfg.From, os.Stdout), nil
+	case "file":
+		if err := os.MkdirAll(cfg.OutboxDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create mail outbox: %w", err)
+		}
+		return NewFileOutbox(cfg.From, cfg.OutboxDir), nil
+	case "smtp":
+		return NewSMTP(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", backend)
+	}
+}
+
+// format renders msg as an RFC 5322 message with CRLF line endings, as sent over SMTP.
+func format(from string, msg Message, date time.Time) ([]byte, error) {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return nil, ErrInvalidHeader
+	}
+
+	messageID, err := newMessageID(from)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+	header("From", from)
+	header("To", msg.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	buf.WriteString("\r\n")
+
+	body := quotedprintable.NewWriter(&buf)
+	if _, err := body.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\r\n")
+
+	return buf.Bytes(), nil
+}
+
+// newMessageID returns a unique Message-ID in the domain of the sender.
+func newMessageID(from string) (string, error) {
+	domain := "localhost"
+	if address, err := mail.ParseAddress(from); err == nil {
+		domain = address.Address[strings.LastIndex(address.Address, "@")+1:]
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">", nil
+}
+
+// envelopeAddress returns the bare address of a header address like "Name <a@example.com>".
+func envelopeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Address, nil
+}
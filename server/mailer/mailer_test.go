@@ -0,0 +1,151 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var message = Message{
+	To:      "alice@example.com",
+	Subject: "Bestätige deine E-Mail-Adresse",
+	Body:    "Open the link:\nhttp://localhost:9044/verify-email?token=abc",
+}
+
+// readBody parses a formatted email and returns its decoded subject and body.
+func readBody(t *testing.T, data []byte) (string, string) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	assert.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.NoError(t, err)
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	assert.NoError(t, err)
+	return subject, string(body)
+}
+
+func TestFormat(t *testing.T) {
+	data, err := format("PasskeyDemo <no-reply@example.com>", message, time.Now())
+	assert.NoError(t, err)
+
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, "PasskeyDemo <no-reply@example.com>", msg.Header.Get("From"))
+	assert.Equal(t, "alice@example.com", msg.Header.Get("To"))
+	assert.True(t, strings.HasSuffix(msg.Header.Get("Message-ID"), "@example.com>"))
+
+	subject, body := readBody(t, data)
+	assert.Equal(t, message.Subject, subject)
+	assert.Equal(t, "Open the link:\r\nhttp://localhost:9044/verify-email?token=abc\r\n", body)
+
+	_, err = format("no-reply@example.com", Message{To: "alice@example.com\r\nBcc: mallory@example.com"}, time.Now())
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+}
+
+func TestWriterOutbox(t *testing.T) {
+	var buf bytes.Buffer
+	outbox := NewWriterOutbox("no-reply@example.com", &buf)
+
+	assert.NoError(t, outbox.Send(context.Background(), message))
+	assert.Contains(t, buf.String(), "To: alice@example.com\n")
+	assert.NotContains(t, buf.String(), "\r")
+}
+
+func TestFileOutbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	outbox, err := New(config.MailConfig{Backend: "file", From: "no-reply@example.com", OutboxDir: dir})
+	assert.NoError(t, err)
+
+	assert.NoError(t, outbox.Send(context.Background(), message))
+	assert.NoError(t, outbox.Send(context.Background(), message))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	data, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	_, body := readBody(t, data)
+	assert.Contains(t, body, "verify-email?token=abc")
+}
+
+func TestSMTP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	// A minimal server that accepts one email and records the conversation
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lines []string
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines = append(lines, line)
+			switch {
+			case inData && line == ".":
+				inData = false
+				reply("250 queued")
+			case inData:
+			case strings.HasPrefix(line, "EHLO"):
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case strings.HasPrefix(line, "AUTH"):
+				reply("235 authenticated")
+			case line == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				received <- lines
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+		received <- lines
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	smtp := NewSMTP(config.MailConfig{
+		From:         "PasskeyDemo <no-reply@example.com>",
+		SMTPHost:     host,
+		SMTPPort:     portNumber,
+		SMTPUsername: "user",
+		SMTPPassword: "password",
+	})
+	assert.NoError(t, smtp.Send(context.Background(), message))
+
+	lines := <-received
+	assert.Contains(t, lines, "MAIL FROM:<no-reply@example.com>")
+	assert.Contains(t, lines, "RCPT TO:<alice@example.com>")
+	assert.Contains(t, lines, "To: alice@example.com")
+	assert.True(t, strings.HasPrefix(lines[1], "AUTH PLAIN"))
+}
@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriterOutbox writes every email to a writer, e.g. stdout, instead of delivering it.
+type WriterOutbox struct {
+	from string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+func NewWriterOutbox(from string, w io.Writer) *WriterOutbox {
+	return &WriterOutbox{from: from, w: w}
+}
+
+func (o *WriterOutbox) Send(_ context.Context, msg Message) error {
+	data, err := format(o.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	// Line endings are converted back, so terminals do not show the carriage returns
+	_, err = io.WriteString(o.w, strings.ReplaceAll(string(data), "\r\n", "\n")+"\n")
+	return err
+}
+
+// FileOutbox writes every email to its own .eml file in a directory, which mail clients can open.
+type FileOutbox struct {
+	from string
+	dir  string
+}
+
+func NewFileOutbox(from string, dir string) *FileOutbox {
+	return &FileOutbox{from: from, dir: dir}
+}
+
+func (o *FileOutbox) Send(_ context.Context, msg Message) error {
+	now := time.Now()
+	data, err := format(o.from, msg, now)
+	if err != nil {
+		return err
+	}
+
+	// Named by time, so the directory lists the emails in order, and made unique by the pattern
+	file, err := os.CreateTemp(o.dir, now.UTC().Format("20060102T150405.000000000Z")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -2,17 +2,18 @@ package main
 
 import (
 	"log"
+	"os"
 
-	"github.com/joho/godotenv"
+	"github.com/shangsuru/passkey-demo/config"
 )
 
 func main() {
-	err := godotenv.Load(".env")
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
 	}
 
-	s, err := NewServer()
+	s, err := NewServer(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
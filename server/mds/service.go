@@ -0,0 +1,214 @@
+package mds
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-webauthn/webauthn/metadata"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+// Entry is the part of a metadata blob entry that the server uses.
+type Entry struct {
+	AAGUID            string                  `json:"aaguid"`
+	MetadataStatement Statement               `json:"metadataStatement"`
+	StatusReports     []metadata.StatusReport `json:"statusReports"`
+}
+
+type Statement struct {
+	Description string `json:"description"`
+	// Data URL of the authenticator's icon
+	Icon string `json:"icon"`
+}
+
+// Status returns the most recent status of the authenticator model.
+func (e Entry) Status() metadata.AuthenticatorStatus {
+	var latest metadata.StatusReport
+	for _, report := range e.StatusReports {
+		// ISO-8601 dates compare like strings
+		if latest.Status == "" || report.EffectiveDate >= latest.EffectiveDate {
+			latest = report
+		}
+	}
+	return latest.Status
+}
+
+// Compromised reports whether the authenticator model must not be trusted anymore, e.g.
+// because its attestation key leaked.
+func (e Entry) Compromised() bool {
+	return metadata.IsUndesiredAuthenticatorStatus(e.Status())
+}
+
+type blob struct {
+	Number  int     `json:"no"`
+	Entries []Entry `json:"entries"`
+}
+
+// Service looks up authenticator models in a FIDO Metadata Service blob. The blob is read from
+// a local file rather than downloaded, so the server also works offline. A nil Service knows
+// no authenticators.
+type Service struct {
+	path  string
+	roots *x509.CertPool
+
+	mu      sync.RWMutex
+	number  int
+	entries map[uuid.UUID]Entry
+}
+
+// NewService loads the configured blob. It returns nil if no blob is configured.
+func NewService(cfg config.MetadataConfig) (*Service, error) {
+	if cfg.Blob == "" {
+		return nil, nil
+	}
+
+	roots := x509.NewCertPool()
+	if cfg.RootCertificate == "" {
+		der, err := base64.StdEncoding.DecodeString(metadata.ProductionMDSRoot)
+		if err != nil {
+			return nil, err
+		}
+		root, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		roots.AddCert(root)
+	} else {
+		pem, err := os.ReadFile(cfg.RootCertificate)
+		if err != nil {
+			return nil, err
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.RootCertificate)
+		}
+	}
+
+	s := &Service{path: cfg.Blob, roots: roots}
+	if err := s.Load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load reads the blob file again. The entries are only replaced by a newer blob with a
+// valid signature, so a broken or outdated file keeps the current entries in place.
+func (s *Service) Load() error {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	b, err := s.parse(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid metadata blob %s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b.Number < s.number {
+		return fmt.Errorf("metadata blob %s has number %d, but %d is already loaded", s.path, b.Number, s.number)
+	}
+
+	entries := make(map[uuid.UUID]Entry)
+	for _, entry := range b.Entries {
+		// UAF authenticators are identified by AAID instead
+		aaguid, err := uuid.Parse(entry.AAGUID)
+		if err != nil {
+			continue
+		}
+		entries[aaguid] = entry
+	}
+
+	s.number = b.Number
+	s.entries = entries
+	return nil
+}
+
+// Number returns the serial number of the loaded blob.
+func (s *Service) Number() int {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.number
+}
+
+// Lookup returns the metadata of the authenticator model with the given AAGUID.
+func (s *Service) Lookup(aaguid uuid.UUID) (Entry, bool) {
+	if s == nil {
+		return Entry{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.entries[aaguid]
+	return entry, ok
+}
+
+// parse verifies the signature of the blob, a JWT signed by the certificate chain in its
+// x5c header, and returns its payload. Certificate revocation is not checked, as that
+// would require network access.
+func (s *Service) parse(token string) (blob, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}))
+
+	_, err := parser.Parse(token, func(token *jwt.Token) (interface{}, error) {
+		x5c, ok := token.Header["x5c"].([]interface{})
+		if !ok || len(x5c) == 0 {
+			return nil, errors.New("missing x5c header")
+		}
+
+		certificates := make([]*x509.Certificate, len(x5c))
+		for i, c := range x5c {
+			encoded, ok := c.(string)
+			if !ok {
+				return nil, errors.New("invalid certificate in x5c header")
+			}
+			der, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, err
+			}
+			if certificates[i], err = x509.ParseCertificate(der); err != nil {
+				return nil, err
+			}
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, certificate := range certificates[1:] {
+			intermediates.AddCert(certificate)
+		}
+		_, err := certificates[0].Verify(x509.VerifyOptions{
+			Roots:         s.roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return certificates[0].PublicKey, nil
+	})
+	if err != nil {
+		return blob{}, err
+	}
+
+	// The claims are decoded again, as they were only parsed into a generic map
+	payload, err := parser.DecodeSegment(strings.Split(token, ".")[1])
+	if err != nil {
+		return blob{}, err
+	}
+
+	var b blob
+	err = json.Unmarshal(payload, &b)
+	return b, err
+}
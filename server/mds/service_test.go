@@ -0,0 +1,116 @@
+package mds
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/internal/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+var yubiKey = uuid.MustParse("fa2b99dc-9e39-4257-8f92-4a30d23c4118")
+
+// writeBlob signs a blob with the given entries and writes it to path.
+func writeBlob(t *testing.T, path string, number int, entries []any, signer *x509.Certificate, key *ecdsa.PrivateKey) {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"no":         number,
+		"nextUpdate": "2026-11-01",
+		"entries":    entries,
+	})
+	token.Header["x5c"] = []string{base64.StdEncoding.EncodeToString(signer.Raw)}
+
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, []byte(signed+"\n"), 0o600))
+}
+
+func TestService(t *testing.T) {
+	root, rootKey := testutil.NewCertificate(t, "MDS root", nil, nil)
+	signer, signerKey := testutil.NewCertificate(t, "MDS signer", root, rootKey)
+	otherRoot, otherRootKey := testutil.NewCertificate(t, "Other root", nil, nil)
+	otherSigner, otherSignerKey := testutil.NewCertificate(t, "Other signer", otherRoot, otherRootKey)
+
+	dir := t.TempDir()
+	rootFile := filepath.Join(dir, "root.pem")
+	assert.NoError(t, os.WriteFile(rootFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0o600))
+	blobFile := filepath.Join(dir, "blob.jwt")
+	cfg := config.MetadataConfig{Blob: blobFile, RootCertificate: rootFile}
+
+	entries := []any{
+		map[string]any{
+			"aaguid":            yubiKey.String(),
+			"metadataStatement": map[string]any{"description": "YubiKey 5 Series"},
+			"statusReports": []any{
+				map[string]any{"status": "FIDO_CERTIFIED", "effectiveDate": "2020-05-12"},
+			},
+		},
+		// UAF entries have no AAGUID
+		map[string]any{"aaid": "4e4e#4005"},
+	}
+	writeBlob(t, blobFile, 10, entries, signer, signerKey)
+
+	t.Run("no blob configured", func(t *testing.T) {
+		service, err := NewService(config.MetadataConfig{})
+		assert.NoError(t, err)
+		assert.Nil(t, service)
+
+		_, ok := service.Lookup(yubiKey)
+		assert.False(t, ok)
+	})
+
+	service, err := NewService(cfg)
+	assert.NoError(t, err)
+
+	t.Run("lookup", func(t *testing.T) {
+		entry, ok := service.Lookup(yubiKey)
+		assert.True(t, ok)
+		assert.Equal(t, "YubiKey 5 Series", entry.MetadataStatement.Description)
+		assert.False(t, entry.Compromised())
+
+		_, ok = service.Lookup(uuid.New())
+		assert.False(t, ok)
+	})
+
+	t.Run("refresh with newer blob", func(t *testing.T) {
+		entries[0].(map[string]any)["statusReports"] = []any{
+			map[string]any{"status": "FIDO_CERTIFIED", "effectiveDate": "2020-05-12"},
+			map[string]any{"status": "ATTESTATION_KEY_COMPROMISE", "effectiveDate": "2026-10-01"},
+		}
+		writeBlob(t, blobFile, 11, entries, signer, signerKey)
+
+		assert.NoError(t, service.Load())
+		assert.Equal(t, 11, service.Number())
+		entry, _ := service.Lookup(yubiKey)
+		assert.True(t, entry.Compromised())
+	})
+
+	t.Run("older blob is rejected", func(t *testing.T) {
+		writeBlob(t, blobFile, 9, nil, signer, signerKey)
+
+		assert.Error(t, service.Load())
+		assert.Equal(t, 11, service.Number())
+	})
+
+	t.Run("blob signed under another root is rejected", func(t *testing.T) {
+		writeBlob(t, blobFile, 12, nil, otherSigner, otherSignerKey)
+
+		assert.Error(t, service.Load())
+		_, ok := service.Lookup(yubiKey)
+		assert.True(t, ok)
+	})
+
+	t.Run("tampered blob is rejected", func(t *testing.T) {
+		writeBlob(t, blobFile, 12, nil, signer, otherSignerKey)
+
+		assert.Error(t, service.Load())
+		assert.Equal(t, 11, service.Number())
+	})
+}
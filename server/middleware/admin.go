@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/handler"
+)
+
+// Admin only lets requests through that present the configured admin token as bearer token.
+// Without a configured token the admin endpoints do not exist.
+type Admin struct {
+	Token string
+}
+
+func NewAdmin(cfg config.AdminConfig) Admin {
+	return Admin{Token: cfg.Token}
+}
+
+func (a Admin) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		if a.Token == "" {
+			return echo.ErrNotFound
+		}
+
+		token, ok := strings.CutPrefix(ctx.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			return ctx.JSON(http.StatusUnauthorized, handler.Response{Status: "error", ErrorMessage: "Invalid admin token."})
+		}
+
+		return next(ctx)
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmin_Handle(t *testing.T) {
+	e := echo.New()
+	next := func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+
+	request := func(admin Admin, authorization string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(echo.POST, "/admin/unlock", nil)
+		if authorization != "" {
+			req.Header.Set(echo.HeaderAuthorization, authorization)
+		}
+		rec := httptest.NewRecorder()
+		return rec, admin.Handle(next)(e.NewContext(req, rec))
+	}
+
+	t.Run("disabled without token", func(t *testing.T) {
+		_, err := request(Admin{}, "Bearer ")
+		assert.ErrorIs(t, err, echo.ErrNotFound)
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		rec, err := request(Admin{Token: "secret"}, "Bearer guess")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("correct token", func(t *testing.T) {
+		rec, err := request(Admin{Token: "secret"}, "Bearer secret")
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
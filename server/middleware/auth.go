@@ -1,16 +1,36 @@
 package middleware
 
 import (
-	"github.com/labstack/echo-contrib/session"
-	"github.com/labstack/echo/v4"
+	"errors"
 	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/handler"
 )
 
-func Auth(next echo.HandlerFunc) echo.HandlerFunc {
+// Auth only lets signed-in users through and renews their session on activity. Everyone else is
+// redirected to the login page, or gets a JSON error for API calls.
+type Auth struct {
+	SessionManager handler.SessionManager
+}
+
+func (a Auth) Handle(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		sess, _ := session.Get("auth", ctx)
-		if userID, ok := sess.Values["user"]; !ok || userID == nil {
-			return ctx.Redirect(http.StatusFound, "/")
+		loginSession, err := a.SessionManager.Session(ctx)
+		if err != nil {
+			if !strings.HasPrefix(ctx.Request().URL.Path, "/api/") {
+				return ctx.Redirect(http.StatusFound, "/")
+			}
+			message := "Not logged in."
+			if errors.Is(err, handler.ErrSessionExpired) {
+				message = "Session expired."
+			}
+			return ctx.JSON(http.StatusUnauthorized, handler.Response{Status: "error", ErrorMessage: message})
+		}
+
+		if err := a.SessionManager.Renew(ctx, loginSession); err != nil {
+			return ctx.JSON(http.StatusInternalServerError, handler.Response{Status: "error", ErrorMessage: err.Error()})
 		}
 
 		return next(ctx)
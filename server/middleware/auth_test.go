@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/handler"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuth_Handle(t *testing.T) {
+	e := echo.New()
+	auth := Auth{SessionManager: handler.SessionManager{Name: "auth"}}
+	next := func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+	handle := session.Middleware(sessions.NewCookieStore([]byte("secret")))(auth.Handle(next))
+
+	t.Run("page redirects to login", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/home", nil)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, handle(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "/", rec.Header().Get(echo.HeaderLocation))
+	})
+
+	t.Run("API call gets a JSON error", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/api/passkeys", nil)
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, handle(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Not logged in."}`, rec.Body.String())
+	})
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+// ClientIP returns how the router determines the IP of a client, which the rate limits and
+// the list of sessions rely on. The X-Forwarded-For header is only honored when the request
+// comes from one of the trusted proxies, as any client can send it.
+func ClientIP(cfg config.ServerConfig) echo.IPExtractor {
+	ranges := cfg.TrustedProxyRanges()
+	if len(ranges) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, ipRange := range ranges {
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
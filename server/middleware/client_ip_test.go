@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	request := func(remoteAddr string, forwardedFor string) *http.Request {
+		req := httptest.NewRequest(echo.POST, "/login/password", strings.NewReader(`{}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		req.Header.Set(echo.HeaderXRealIP, forwardedFor)
+		return req
+	}
+
+	t.Run("spoofed headers do not reset the per-IP limit", func(t *testing.T) {
+		e := echo.New()
+		e.IPExtractor = ClientIP(config.ServerConfig{})
+		store := ratelimit.NewMemoryStore(time.Hour)
+		defer store.Close()
+		rateLimit := RateLimit{Limiter: ratelimit.NewLimiter(store, config.RateLimitConfig{
+			Window:      time.Minute,
+			PerIP:       1,
+			PerUsername: 100,
+			Global:      100,
+		})}
+		next := func(ctx echo.Context) error {
+			return ctx.NoContent(http.StatusOK)
+		}
+
+		for i, forwardedFor := range []string{"203.0.113.1", "203.0.113.2"} {
+			rec := httptest.NewRecorder()
+			assert.NoError(t, rateLimit.Handle(next)(e.NewContext(request("192.0.2.1:1234", forwardedFor), rec)))
+			if i == 0 {
+				assert.Equal(t, http.StatusOK, rec.Code)
+			} else {
+				assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+			}
+		}
+	})
+
+	t.Run("forwarded address from a trusted proxy", func(t *testing.T) {
+		e := echo.New()
+		e.IPExtractor = ClientIP(config.ServerConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+
+		ctx := e.NewContext(request("10.1.2.3:1234", "203.0.113.1"), httptest.NewRecorder())
+		assert.Equal(t, "203.0.113.1", ctx.RealIP())
+
+		// Another private address is not trusted just for being private
+		ctx = e.NewContext(request("192.168.0.1:1234", "203.0.113.1"), httptest.NewRecorder())
+		assert.Equal(t, "192.168.0.1", ctx.RealIP())
+
+		// Addresses the client prepended are skipped
+		ctx = e.NewContext(request("10.1.2.3:1234", "198.51.100.7, 203.0.113.1"), httptest.NewRecorder())
+		assert.Equal(t, "203.0.113.1", ctx.RealIP())
+	})
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCSRF_Handle(t *testing.T) {
+	e := echo.New()
+	csrf := CSRF{
+		AllowedOrigins: []string{"https://example.com", "https://example.org"},
+		ExemptPaths:    []string{"/admin/"},
+	}
+	next := func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+
+	request := func(method string, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, csrf.Handle(next)(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("same origin", func(t *testing.T) {
+		rec := request(echo.POST, "/logout", map[string]string{"Sec-Fetch-Site": "same-origin", "Origin": "https://example.com"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("related origin", func(t *testing.T) {
+		rec := request(echo.POST, "/logout", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://EXAMPLE.org"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("cross-site", func(t *testing.T) {
+		rec := request(echo.POST, "/logout", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example.net"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Cross-site request rejected."}`, rec.Body.String())
+	})
+
+	t.Run("foreign origin without fetch metadata", func(t *testing.T) {
+		rec := request(echo.DELETE, "/api/sessions", map[string]string{"Origin": "https://evil.example.net"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("cross-site without origin", func(t *testing.T) {
+		rec := request(echo.POST, "/logout", map[string]string{"Sec-Fetch-Site": "cross-site"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("safe method", func(t *testing.T) {
+		rec := request(echo.GET, "/api/sessions", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example.net"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("non-browser client", func(t *testing.T) {
+		rec := request(echo.POST, "/login/password", nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("bearer token on exempt path", func(t *testing.T) {
+		rec := request(echo.POST, "/admin/unlock", map[string]string{"Origin": "https://evil.example.net", "Authorization": "Bearer token"})
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("bearer token on other path", func(t *testing.T) {
+		rec := request(echo.POST, "/logout", map[string]string{"Origin": "https://evil.example.net", "Authorization": "Bearer token"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("exempt path without bearer token", func(t *testing.T) {
+		rec := request(echo.POST, "/admin/unlock", map[string]string{"Origin": "https://evil.example.net"})
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/handler"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+)
+
+// RateLimit throttles login and registration requests per IP, per username and in total.
+type RateLimit struct {
+	Limiter ratelimit.Limiter
+}
+
+func (r RateLimit) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		body, err := io.ReadAll(ctx.Request().Body)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, handler.Response{Status: "error", ErrorMessage: err.Error()})
+		}
+		ctx.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+		// Requests without a username are only limited per IP and in total
+		var p handler.Params
+		_ = json.Unmarshal(body, &p)
+
+		retryAfter, err := r.Limiter.Allow(ctx.Request().Context(), ctx.RealIP(), p.Username)
+		if err != nil {
+			// An unavailable store must not lock everyone out
+			ctx.Logger().Errorf("rate limiting failed: %v", err)
+			return next(ctx)
+		}
+		if retryAfter > 0 {
+			ctx.Response().Header().Set(echo.HeaderRetryAfter, ratelimit.RetryAfter(retryAfter))
+			return ctx.JSON(http.StatusTooManyRequests, handler.Response{Status: "error", ErrorMessage: "Too many requests. Please try again later."})
+		}
+
+		return next(ctx)
+	}
+}
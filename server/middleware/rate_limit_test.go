@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/ratelimit"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit_Handle(t *testing.T) {
+	e := echo.New()
+	store := ratelimit.NewMemoryStore(time.Hour)
+	defer store.Close()
+	rateLimit := RateLimit{Limiter: ratelimit.NewLimiter(store, config.RateLimitConfig{
+		Window:      time.Minute,
+		PerIP:       100,
+		PerUsername: 1,
+		Global:      100,
+	})}
+	next := func(ctx echo.Context) error {
+		// The body is still readable by the handler
+		var p struct{ Username string }
+		if err := ctx.Bind(&p); err != nil {
+			return err
+		}
+		return ctx.String(http.StatusOK, p.Username)
+	}
+
+	login := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.POST, "/login/password", strings.NewReader(`{"username":"alice"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, rateLimit.Handle(next)(e.NewContext(req, rec)))
+		return rec
+	}
+
+	rec := login()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "alice", rec.Body.String())
+
+	rec = login()
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "60", rec.Header().Get(echo.HeaderRetryAfter))
+	assert.JSONEq(t, `{"status": "error", "errorMessage":"Too many requests. Please try again later."}`, rec.Body.String())
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/handler"
+)
+
+// Reauth guards sensitive operations. It only lets users through who logged in or re-authenticated
+// within the re-authentication window, and expects to run after Auth.
+type Reauth struct {
+	SessionManager handler.SessionManager
+}
+
+func (r Reauth) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		loginSession, err := r.SessionManager.Session(ctx)
+		if err != nil {
+			return ctx.JSON(http.StatusUnauthorized, handler.Response{Status: "error", ErrorMessage: "Not logged in."})
+		}
+
+		if !r.SessionManager.RecentlyAuthenticated(loginSession) {
+			return ctx.JSON(http.StatusForbidden, handler.Response{Status: "error", ErrorMessage: "Reauthentication required."})
+		}
+
+		return next(ctx)
+	}
+}
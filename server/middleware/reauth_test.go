@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/db"
+	"github.com/shangsuru/passkey-demo/handler"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/shangsuru/passkey-demo/repository"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReauth_Handle(t *testing.T) {
+	database := db.GetTestDB()
+	defer database.Close()
+
+	e := echo.New()
+	withSession := session.Middleware(sessions.NewCookieStore([]byte("secret")))
+	sessionManager := handler.SessionManager{
+		Name:               "auth",
+		SessionRepository:  repository.SessionRepository{DB: database},
+		IdleTimeout:        time.Hour,
+		AbsoluteLifetime:   time.Hour,
+		RememberMeLifetime: time.Hour,
+		ReauthWindow:       5 * time.Minute,
+	}
+	reauth := Reauth{SessionManager: sessionManager}
+	next := func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+
+	req := httptest.NewRequest(echo.POST, "/login", nil)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, withSession(func(ctx echo.Context) error {
+		return sessionManager.Create(ctx, uuid.New(), handler.AuthMethodPasskey, false)
+	})(e.NewContext(req, rec)))
+	cookie := rec.Result().Cookies()[0]
+
+	deletePasskey := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(echo.DELETE, "/api/passkeys/1", nil)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, withSession(reauth.Handle(next))(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("right after login", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, deletePasskey().Code)
+	})
+
+	t.Run("authentication is too old", func(t *testing.T) {
+		_, err := database.NewUpdate().
+			Model((*model.LoginSession)(nil)).
+			Set("reauthenticated_at = ?", time.Now().Add(-time.Hour)).
+			Where("1 = 1").
+			Exec(context.Background())
+		assert.NoError(t, err)
+
+		rec := deletePasskey()
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Reauthentication required."}`, rec.Body.String())
+	})
+}
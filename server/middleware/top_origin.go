@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/handler"
+)
+
+// TopOrigin rejects ceremonies that ran in a cross-origin iframe unless the embedding page is an
+// allowed top origin. The WebAuthn library only verifies the origin of the iframe itself.
+type TopOrigin struct {
+	AllowedTopOrigins []string
+}
+
+func NewTopOrigin(cfg config.WebAuthnConfig) TopOrigin {
+	return TopOrigin{AllowedTopOrigins: cfg.RPTopOrigins}
+}
+
+// clientData holds the members of the client data that the library does not parse.
+type clientData struct {
+	CrossOrigin bool   `json:"crossOrigin"`
+	TopOrigin   string `json:"topOrigin"`
+}
+
+func (t TopOrigin) Handle(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		body, err := io.ReadAll(ctx.Request().Body)
+		if err != nil {
+			return ctx.JSON(http.StatusBadRequest, handler.Response{Status: "error", ErrorMessage: err.Error()})
+		}
+		ctx.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+		var credential struct {
+			Response struct {
+				ClientDataJSON protocol.URLEncodedBase64 `json:"clientDataJSON"`
+			} `json:"response"`
+		}
+		var data clientData
+		// Malformed credentials are left to the handler, which reports them in detail
+		if json.Unmarshal(body, &credential) != nil || json.Unmarshal(credential.Response.ClientDataJSON, &data) != nil {
+			return next(ctx)
+		}
+
+		if data.CrossOrigin && !t.allowed(data.TopOrigin) {
+			return ctx.JSON(http.StatusBadRequest, handler.Response{Status: "error", ErrorMessage: "Passkeys cannot be used from this site."})
+		}
+
+		return next(ctx)
+	}
+}
+
+func (t TopOrigin) allowed(origin string) bool {
+	return slices.ContainsFunc(t.AllowedTopOrigins, func(allowed string) bool {
+		return strings.EqualFold(allowed, origin)
+	})
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopOrigin_Handle(t *testing.T) {
+	e := echo.New()
+	topOrigin := TopOrigin{AllowedTopOrigins: []string{"https://shop.example.com"}}
+	next := func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+
+	finish := func(clientData string) *httptest.ResponseRecorder {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(clientData))
+		req := httptest.NewRequest(echo.POST, "/login/finish", strings.NewReader(`{"response":{"clientDataJSON":"`+encoded+`"}}`))
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, topOrigin.Handle(next)(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("same origin", func(t *testing.T) {
+		rec := finish(`{"type":"webauthn.get","origin":"https://example.com","crossOrigin":false}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("allowed top origin", func(t *testing.T) {
+		rec := finish(`{"type":"webauthn.get","origin":"https://example.com","crossOrigin":true,"topOrigin":"https://SHOP.example.com"}`)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("unknown top origin", func(t *testing.T) {
+		rec := finish(`{"type":"webauthn.get","origin":"https://example.com","crossOrigin":true,"topOrigin":"https://evil.example.net"}`)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"status": "error", "errorMessage":"Passkeys cannot be used from this site."}`, rec.Body.String())
+	})
+
+	t.Run("malformed credential is left to the handler", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/login/finish", strings.NewReader(`{}`))
+		rec := httptest.NewRecorder()
+
+		assert.NoError(t, topOrigin.Handle(next)(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
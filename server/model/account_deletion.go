@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AccountDeletion records that an account was deleted and how the user confirmed it.
+type AccountDeletion struct {
+	ID        uuid.UUID `json:"id" bun:"id,pk"`
+	UserID    uuid.UUID `json:"user_id" bun:"user_id"`
+	Method    string    `json:"method" bun:"method"`
+	CreatedAt time.Time `json:"created_at" bun:"created_at"`
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginSession is the server-side record of a signed-in browser. The session cookie only holds its ID,
+// so deleting the record revokes the cookie.
+type LoginSession struct {
+	ID         uuid.UUID `json:"id" bun:"id,pk"`
+	UserID     uuid.UUID `json:"user_id" bun:"user_id"`
+	IP         string    `json:"ip" bun:"ip"`
+	UserAgent  string    `json:"user_agent" bun:"user_agent"`
+	AuthMethod string    `json:"auth_method" bun:"auth_method"`
+	RememberMe bool      `json:"remember_me" bun:"remember_me"`
+	CreatedAt  time.Time `json:"created_at" bun:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at" bun:"last_seen_at"`
+	// End of the absolute lifetime, which activity does not extend
+	ExpiresAt time.Time `json:"expires_at" bun:"expires_at"`
+	// Last time the user proved their identity in this session, at login or by re-authenticating
+	ReauthenticatedAt *time.Time `json:"reauthenticated_at" bun:"reauthenticated_at"`
+	// Set while the password was checked but the second factor is still missing. Such a
+	// session does not sign the user in.
+	SecondFactorPending bool `json:"second_factor_pending" bun:"second_factor_pending"`
+	// Set after a login with a recovery code. Such a session only allows registering a
+	// new passkey, which then signs the user in.
+	PasskeyRequired bool `json:"passkey_required" bun:"passkey_required"`
+}
+
+// Complete reports whether the session signs the user in, rather than waiting for another step.
+func (s *LoginSession) Complete() bool {
+	return !s.SecondFactorPending && !s.PasskeyRequired
+}
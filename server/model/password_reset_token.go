@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordResetToken is the token of a link for setting a new password. Only its hash is stored,
+// and it is deleted once used.
+type PasswordResetToken struct {
+	ID        uuid.UUID `json:"id" bun:"id,pk"`
+	UserID    uuid.UUID `json:"user_id" bun:"user_id"`
+	TokenHash string    `json:"-" bun:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at" bun:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bun:"created_at"`
+}
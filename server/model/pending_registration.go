@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PendingRegistration reserves a username while its passkey registration ceremony is in progress.
+// The ID becomes the ID of the user once the ceremony completes.
+type PendingRegistration struct {
+	ID        uuid.UUID `json:"id" bun:"id,pk"`
+	Username  string    `json:"username" bun:"username"`
+	ExpiresAt time.Time `json:"expires_at" bun:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bun:"created_at"`
+}
+
+// User returns the not yet persisted user that is being registered.
+func (p *PendingRegistration) User() *User {
+	return &User{
+		ID:       p.ID,
+		Username: p.Username,
+	}
+}
@@ -0,0 +1,17 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RecoveryCode is one of the single-use codes a user can sign in with after losing their passkeys.
+// Only the hash of the code is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID  `json:"id" bun:"id,pk"`
+	UserID    uuid.UUID  `json:"user_id" bun:"user_id"`
+	CodeHash  string     `json:"-" bun:"code_hash"`
+	UsedAt    *time.Time `json:"used_at" bun:"used_at"`
+	CreatedAt time.Time  `json:"created_at" bun:"created_at"`
+}
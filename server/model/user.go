@@ -12,9 +12,20 @@ type User struct {
 	ID                  uuid.UUID             `json:"id" bun:"id,pk"`
 	Username            string                `json:"username" bun:"username"`
 	WebauthnCredentials []WebauthnCredentials `json:"webauthn_credentials" bun:"rel:has-many,join:id=user_id"`
-	PasswordHash        string                `json:"-" bun:"password_hash,notnull"`
-	CreatedAt           time.Time             `json:"created_at" bun:"created_at"`
-	UpdatedAt           time.Time             `json:"updated_at" bun:"updated_at"`
+	PasswordHash        string                `json:"-" bun:"password_hash,nullzero"` // empty for passkey-only accounts
+	// TOTP secret of the second factor for password logins. It is only in use once enrollment
+	// was confirmed at TOTPEnabledAt. TOTPLastCounter is the period of the last accepted code.
+	TOTPSecret      string     `json:"-" bun:"totp_secret,nullzero"`
+	TOTPEnabledAt   *time.Time `json:"totp_enabled_at" bun:"totp_enabled_at"`
+	TOTPLastCounter int64      `json:"-" bun:"totp_last_counter"`
+	// Optional email address, only stored once the user verified it at EmailVerifiedAt
+	Email           string     `json:"email,omitempty" bun:"email,nullzero"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" bun:"email_verified_at"`
+	// Counts the changes of the address, so a verification link only works once and only until
+	// the address changes otherwise
+	EmailVersion int64     `json:"-" bun:"email_version"`
+	CreatedAt    time.Time `json:"created_at" bun:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at" bun:"updated_at"`
 }
 
 func (u *User) WebAuthnID() []byte {
@@ -38,19 +49,33 @@ func (u *User) WebAuthnCredentials() []webauthn.Credential {
 	credentials := make([]webauthn.Credential, len(u.WebauthnCredentials))
 
 	for i, v := range u.WebauthnCredentials {
+		// The sign_count column is authoritative, as it is the one compared when storing new logins
+		authenticator := v.Authenticator
+		authenticator.SignCount = v.SignCount
+
 		credentials[i] = webauthn.Credential{
 			ID:              v.CredentialID,
 			PublicKey:       v.PublicKey,
 			AttestationType: v.AttestationType,
 			Transport:       v.Transport,
 			Flags:           v.Flags,
-			Authenticator:   v.Authenticator,
+			Authenticator:   authenticator,
 		}
 	}
 
 	return credentials
 }
 
+// HasPassword reports whether the user can sign in with a password.
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+// TOTPEnabled reports whether password logins require a TOTP code.
+func (u *User) TOTPEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
 func (u *User) CredentialExcludeList() []protocol.CredentialDescriptor {
 	var credentialExcludeList []protocol.CredentialDescriptor
 	for _, cred := range u.WebauthnCredentials {
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
@@ -9,12 +11,21 @@ import (
 type WebauthnCredentials struct {
 	ID              uuid.UUID                         `json:"id" bun:"id,pk"`
 	UserID          uuid.UUID                         `json:"user_id" bun:"user_id"`
+	Name            string                            `json:"name" bun:"name"`
 	CredentialID    []byte                            `json:"credential_id" bun:"credential_id"`
 	PublicKey       []byte                            `json:"public_key" bun:"public_key"`
 	AttestationType string                            `json:"attestation_type" bun:"attestation_type"`
 	Transport       []protocol.AuthenticatorTransport `json:"transport" bun:"transport,array"`
 	Flags           webauthn.CredentialFlags          `json:"flags" bun:"flags"`
 	Authenticator   webauthn.Authenticator            `json:"authenticator" bun:"authenticator"`
+	SignCount       uint32                            `json:"sign_count" bun:"sign_count"`
+	// The CBOR attestation object of the registration with the attestation statement and its
+	// certificate chain, kept so the attestation policy can be re-evaluated. Its format is in
+	// AttestationType. Both are missing for credentials registered before they were stored.
+	AttestationObject []byte     `json:"attestation_object" bun:"attestation_object"`
+	AAGUID            uuid.UUID  `json:"aaguid" bun:"aaguid,nullzero"`
+	CreatedAt         time.Time  `json:"created_at" bun:"created_at"`
+	LastUsedAt        *time.Time `json:"last_used_at" bun:"last_used_at"`
 }
 
 // Required fields for this struct are taken from https://github.com/go-webauthn/webauthn/blob/master/webauthn/credential.go
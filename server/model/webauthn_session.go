@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// WebauthnSession holds the state of a WebAuthn ceremony for the SQL session store.
+type WebauthnSession struct {
+	ID        string    `json:"id" bun:"id,pk"`
+	Data      []byte    `json:"data" bun:"data"`
+	ExpiresAt time.Time `json:"expires_at" bun:"expires_at"`
+	CreatedAt time.Time `json:"created_at" bun:"created_at"`
+}
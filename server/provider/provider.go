@@ -0,0 +1,128 @@
+package provider
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+// Default name of passkeys whose provider and device are unknown
+const defaultName = "Passkey"
+
+//go:embed aaguids.json
+var bundled []byte
+
+// Provider is a password manager, platform or security key that stores passkeys.
+type Provider struct {
+	Name string `json:"name"`
+	// Data URLs of the icon for light and dark backgrounds
+	IconLight string `json:"icon_light,omitempty"`
+	IconDark  string `json:"icon_dark,omitempty"`
+}
+
+// Registry maps AAGUIDs to the providers they identify.
+type Registry map[uuid.UUID]Provider
+
+// NewRegistry returns the bundled providers, updated with those in the configured file.
+func NewRegistry(cfg config.ProvidersConfig) (Registry, error) {
+	registry := Registry{}
+	if err := registry.add(bundled); err != nil {
+		return nil, fmt.Errorf("invalid bundled passkey providers: %w", err)
+	}
+
+	if cfg.File != "" {
+		data, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, err
+		}
+		if err := registry.add(data); err != nil {
+			return nil, fmt.Errorf("invalid passkey providers in %s: %w", cfg.File, err)
+		}
+	}
+
+	return registry, nil
+}
+
+func (r Registry) add(data []byte) error {
+	var providers map[string]Provider
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return err
+	}
+
+	for key, provider := range providers {
+		aaguid, err := uuid.Parse(key)
+		if err != nil {
+			return err
+		}
+		r[aaguid] = provider
+	}
+	return nil
+}
+
+// Lookup returns the provider of passkeys with the given AAGUID.
+func (r Registry) Lookup(aaguid uuid.UUID) (Provider, bool) {
+	provider, ok := r[aaguid]
+	return provider, ok
+}
+
+// CredentialName suggests a name for a new passkey: the provider if it is known, otherwise
+// the browser and operating system it was registered with, e.g. "Chrome on Mac".
+func (r Registry) CredentialName(aaguid uuid.UUID, userAgent string) string {
+	if provider, ok := r.Lookup(aaguid); ok {
+		return provider.Name
+	}
+
+	client, system := browser(userAgent), operatingSystem(userAgent)
+	switch {
+	case client != "" && system != "":
+		return client + " on " + system
+	case system != "":
+		return defaultName + " on " + system
+	default:
+		return defaultName
+	}
+}
+
+// browser names the browser in a User-Agent header. Browsers based on others mention
+// those as well, so they are checked first.
+func browser(userAgent string) string {
+	for _, b := range []struct{ token, name string }{
+		{"Edg", "Edge"},
+		{"OPR/", "Opera"},
+		{"SamsungBrowser/", "Samsung Internet"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			return b.name
+		}
+	}
+	return ""
+}
+
+// operatingSystem names the operating system in a User-Agent header. iOS and Android
+// User-Agents also mention Mac OS X and Linux, so they are checked first.
+func operatingSystem(userAgent string) string {
+	for _, o := range []struct{ token, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Macintosh", "Mac"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			return o.name
+		}
+	}
+	return ""
+}
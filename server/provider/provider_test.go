@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	iCloudKeychain = uuid.MustParse("fbfc3007-154e-4ecc-8c0b-6e020557d7bd")
+	newManager     = uuid.MustParse("00000000-0000-4000-8000-000000000001")
+)
+
+func TestNewRegistry(t *testing.T) {
+	t.Run("bundled providers", func(t *testing.T) {
+		registry, err := NewRegistry(config.ProvidersConfig{})
+		assert.NoError(t, err)
+
+		provider, ok := registry.Lookup(iCloudKeychain)
+		assert.True(t, ok)
+		assert.Equal(t, "iCloud Keychain", provider.Name)
+		assert.Contains(t, provider.IconLight, "data:image/svg+xml;base64,")
+		assert.Contains(t, provider.IconDark, "data:image/svg+xml;base64,")
+	})
+
+	t.Run("file adds and overrides providers", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "aaguids.json")
+		assert.NoError(t, os.WriteFile(file, []byte(`{
+			"`+iCloudKeychain.String()+`": {"name": "Apple Passwords"},
+			"`+newManager.String()+`": {"name": "New Manager", "icon_light": "data:image/png;base64,AA=="}
+		}`), 0o600))
+
+		registry, err := NewRegistry(config.ProvidersConfig{File: file})
+		assert.NoError(t, err)
+
+		provider, _ := registry.Lookup(iCloudKeychain)
+		assert.Equal(t, "Apple Passwords", provider.Name)
+		provider, _ = registry.Lookup(newManager)
+		assert.Equal(t, "New Manager", provider.Name)
+		_, ok := registry.Lookup(uuid.MustParse("ea9b8d66-4d01-1d21-3ce4-b6b48cb575d4"))
+		assert.True(t, ok)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "aaguids.json")
+		assert.NoError(t, os.WriteFile(file, []byte(`{"not an aaguid": {"name": "Broken"}}`), 0o600))
+
+		_, err := NewRegistry(config.ProvidersConfig{File: file})
+		assert.Error(t, err)
+	})
+}
+
+func TestRegistry_CredentialName(t *testing.T) {
+	registry, err := NewRegistry(config.ProvidersConfig{})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		aaguid    uuid.UUID
+		userAgent string
+		want      string
+	}{
+		{"known provider", iCloudKeychain, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", "iCloud Keychain"},
+		{"chrome on mac", uuid.Nil, "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36", "Chrome on Mac"},
+		{"edge on windows", uuid.Nil, "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0", "Edge on Windows"},
+		{"firefox on android", uuid.Nil, "Mozilla/5.0 (Android 14; Mobile; rv:125.0) Gecko/125.0 Firefox/125.0", "Firefox on Android"},
+		{"safari on iphone", uuid.Nil, "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", "Safari on iPhone"},
+		{"unknown browser", uuid.Nil, "Mozilla/5.0 (X11; Linux x86_64)", "Passkey on Linux"},
+		{"no user agent", uuid.Nil, "", "Passkey"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, registry.CredentialName(tt.aaguid, tt.userAgent))
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Package qrcode encodes short texts, like otpauth:// URIs, as QR codes and renders them as PNG.
+//
+// The encoding itself is done by github.com/boombuler/barcode/qr in byte mode at error correction
+// level M, which holds up to 2331 bytes in the largest version.
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+)
+
+// ErrTooLong is returned for data that does not fit into the largest QR code version.
+var ErrTooLong = errors.New("data too long for a QR code")
+
+// MaxLength is the number of bytes the largest version holds at level M.
+const MaxLength = 2331
+
+// Code is an encoded QR code.
+type Code struct {
+	code barcode.Barcode
+}
+
+// Encode returns the smallest QR code holding data.
+func Encode(data []byte) (*Code, error) {
+	if len(data) > MaxLength {
+		return nil, ErrTooLong
+	}
+	code, err := qr.Encode(string(data), qr.M, qr.Unicode)
+	if err != nil {
+		return nil, fmt.Errorf("encoding QR code: %w", err)
+	}
+	return &Code{code: code}, nil
+}
+
+// Size returns the number of modules per side, without the quiet zone.
+func (c *Code) Size() int {
+	return c.code.Bounds().Dx()
+}
+
+// PNG renders the code with the given number of pixels per module and the quiet zone of four
+// modules around it.
+func (c *Code) PNG(scale int) ([]byte, error) {
+	const quietZone = 4
+	modules := c.Size()
+	size := (modules + 2*quietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y := 0; y < modules; y++ {
+		for x := 0; x < modules; x++ {
+			if color.GrayModel.Convert(c.code.At(x, y)).(color.Gray).Y >= 0x80 {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex((x+quietZone)*scale+dx, (y+quietZone)*scale+dy, 1)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,75 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	gozxingqr "github.com/makiuchi-d/gozxing/qrcode"
+	"github.com/stretchr/testify/assert"
+)
+
+func decode(t *testing.T, data []byte) string {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	result, err := gozxingqr.NewQRCodeReader().Decode(bitmap, nil)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	return result.GetText()
+}
+
+func TestEncode(t *testing.T) {
+	tests := map[string]string{
+		"short uri":     "otpauth://totp/Passkey%20Demo:alice?secret=JBSWY3DPEHPK3PXP&issuer=Passkey%20Demo",
+		"long username": "otpauth://totp/Passkey%20Demo:" + strings.Repeat("a", 255) + "?secret=JBSWY3DPEHPK3PXP&issuer=Passkey%20Demo",
+		"non-ascii":     "otpauth://totp/Passkey%20Demo:jürgen?secret=JBSWY3DPEHPK3PXP&issuer=Passkey%20Demo",
+	}
+	for name, text := range tests {
+		t.Run(name, func(t *testing.T) {
+			code, err := Encode([]byte(text))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			data, err := code.PNG(4)
+			assert.NoError(t, err)
+			assert.Equal(t, text, decode(t, data))
+		})
+	}
+
+	t.Run("largest version", func(t *testing.T) {
+		code, err := Encode(bytes.Repeat([]byte("a"), MaxLength))
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, 177, code.Size())
+	})
+
+	t.Run("too long", func(t *testing.T) {
+		_, err := Encode(bytes.Repeat([]byte("a"), MaxLength+1))
+		assert.ErrorIs(t, err, ErrTooLong)
+	})
+}
+
+func TestCode_PNG(t *testing.T) {
+	code, err := Encode([]byte("hello"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	data, err := code.PNG(4)
+	assert.NoError(t, err)
+	img, err := png.Decode(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, (21+8)*4, img.Bounds().Dx())
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+const keyPrefix = "ratelimit:"
+
+// Limiter throttles requests to the login and registration endpoints and locks accounts
+// after repeated failed password logins.
+type Limiter struct {
+	store Store
+	cfg   config.RateLimitConfig
+}
+
+func NewLimiter(store Store, cfg config.RateLimitConfig) Limiter {
+	return Limiter{
+		store: store,
+		cfg:   cfg,
+	}
+}
+
+// limit allows max requests per window under key.
+type limit struct {
+	key string
+	max int
+}
+
+// Allow counts a request against the global, per-IP and, if given, per-username limits. It returns
+// how long the client has to wait if any of them is exceeded, or zero if the request may proceed.
+func (l Limiter) Allow(ctx context.Context, ip string, username string) (time.Duration, error) {
+	limits := []limit{
+		{keyPrefix + "global", l.cfg.Global},
+		{keyPrefix + "ip:" + ip, l.cfg.PerIP},
+	}
+	if username != "" {
+		limits = append(limits, limit{keyPrefix + "username:" + username, l.cfg.PerUsername})
+	}
+
+	var retryAfter time.Duration
+	for _, limit := range limits {
+		count, resetIn, err := l.store.Increment(ctx, limit.key, l.cfg.Window)
+		if err != nil {
+			return 0, err
+		}
+		if count > int64(limit.max) && resetIn > retryAfter {
+			retryAfter = resetIn
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// LockedFor returns how long the account with the given username stays locked.
+func (l Limiter) LockedFor(ctx context.Context, username string) (time.Duration, error) {
+	return l.store.BlockedFor(ctx, lockoutKey(username))
+}
+
+// Fail records a failed password login. Once the failures reach the threshold, the account is locked
+// for a duration that doubles with every further failure. It returns the duration of the lockout.
+func (l Limiter) Fail(ctx context.Context, username string) (time.Duration, error) {
+	failures, _, err := l.store.Increment(ctx, failuresKey(username), l.cfg.LockoutWindow)
+	if err != nil {
+		return 0, err
+	}
+	if failures < int64(l.cfg.LockoutThreshold) {
+		return 0, nil
+	}
+
+	lockout := l.cfg.LockoutDuration
+	for i := int64(l.cfg.LockoutThreshold); i < failures && lockout < l.cfg.MaxLockoutDuration; i++ {
+		lockout *= 2
+	}
+	lockout = min(lockout, l.cfg.MaxLockoutDuration)
+
+	return lockout, l.store.Block(ctx, lockoutKey(username), lockout)
+}
+
+// Unlock forgets the failed logins of the account and lifts its lockout, e.g. after a successful
+// login or on request of an administrator.
+func (l Limiter) Unlock(ctx context.Context, username string) error {
+	return l.store.Reset(ctx, failuresKey(username), lockoutKey(username))
+}
+
+func failuresKey(username string) string {
+	return keyPrefix + "failures:" + username
+}
+
+func lockoutKey(username string) string {
+	return keyPrefix + "lockout:" + username
+}
+
+// RetryAfter formats a wait duration for the Retry-After header in whole seconds, rounded up.
+func RetryAfter(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+
+	t.Run("increment counts within the window", func(t *testing.T) {
+		count, _, err := store.Increment(ctx, "counter", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		count, resetIn, err := store.Increment(ctx, "counter", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+		assert.InDelta(t, time.Minute, resetIn, float64(time.Second))
+	})
+
+	t.Run("increment starts a new window after expiry", func(t *testing.T) {
+		_, _, err := store.Increment(ctx, "expired", -time.Second)
+		assert.NoError(t, err)
+
+		count, _, err := store.Increment(ctx, "expired", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("block and reset", func(t *testing.T) {
+		assert.NoError(t, store.Block(ctx, "block", time.Minute))
+		blockedFor, err := store.BlockedFor(ctx, "block")
+		assert.NoError(t, err)
+		assert.InDelta(t, time.Minute, blockedFor, float64(time.Second))
+
+		assert.NoError(t, store.Reset(ctx, "block"))
+		blockedFor, err = store.BlockedFor(ctx, "block")
+		assert.NoError(t, err)
+		assert.Zero(t, blockedFor)
+	})
+
+	t.Run("janitor removes expired entries", func(t *testing.T) {
+		assert.NoError(t, store.Block(ctx, "stale", -time.Second))
+		store.deleteExpired()
+		assert.NotContains(t, store.entries, "stale")
+	})
+}
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+	limiter := NewLimiter(store, config.RateLimitConfig{
+		Window:             time.Minute,
+		PerIP:              2,
+		PerUsername:        3,
+		Global:             100,
+		LockoutThreshold:   2,
+		LockoutWindow:      time.Hour,
+		LockoutDuration:    time.Minute,
+		MaxLockoutDuration: 3 * time.Minute,
+	})
+
+	t.Run("limits requests per IP", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			retryAfter, err := limiter.Allow(ctx, "192.0.2.1", "")
+			assert.NoError(t, err)
+			assert.Zero(t, retryAfter)
+		}
+
+		retryAfter, err := limiter.Allow(ctx, "192.0.2.1", "")
+		assert.NoError(t, err)
+		assert.Positive(t, retryAfter)
+
+		// Other clients are not affected
+		retryAfter, err = limiter.Allow(ctx, "192.0.2.2", "")
+		assert.NoError(t, err)
+		assert.Zero(t, retryAfter)
+	})
+
+	t.Run("limits requests per username across IPs", func(t *testing.T) {
+		for _, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+			retryAfter, err := limiter.Allow(ctx, ip, "alice")
+			assert.NoError(t, err)
+			assert.Zero(t, retryAfter)
+		}
+
+		retryAfter, err := limiter.Allow(ctx, "198.51.100.4", "alice")
+		assert.NoError(t, err)
+		assert.Positive(t, retryAfter)
+	})
+
+	t.Run("progressive lockout", func(t *testing.T) {
+		var lockouts []time.Duration
+		for i := 0; i < 5; i++ {
+			lockedFor, err := limiter.Fail(ctx, "bob")
+			assert.NoError(t, err)
+			lockouts = append(lockouts, lockedFor)
+		}
+		assert.Equal(t, []time.Duration{0, time.Minute, 2 * time.Minute, 3 * time.Minute, 3 * time.Minute}, lockouts)
+
+		lockedFor, err := limiter.LockedFor(ctx, "bob")
+		assert.NoError(t, err)
+		assert.Positive(t, lockedFor)
+
+		assert.NoError(t, limiter.Unlock(ctx, "bob"))
+		lockedFor, err = limiter.LockedFor(ctx, "bob")
+		assert.NoError(t, err)
+		assert.Zero(t, lockedFor)
+
+		// Failures start over after unlocking
+		lockedFor, err = limiter.Fail(ctx, "bob")
+		assert.NoError(t, err)
+		assert.Zero(t, lockedFor)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, "1", RetryAfter(10*time.Millisecond))
+	assert.Equal(t, "60", RetryAfter(time.Minute))
+	assert.Equal(t, "61", RetryAfter(time.Minute+time.Millisecond))
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// MemoryStore keeps counters in process memory. Limits are per instance, so it is meant for
+// development, tests and single-instance deployments.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	stop    chan struct{}
+}
+
+// NewMemoryStore returns a store that removes expired entries every janitorInterval.
+func NewMemoryStore(janitorInterval time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		stop:    make(chan struct{}),
+	}
+	go s.janitor(janitorInterval)
+
+	return s
+}
+
+func (s *MemoryStore) Increment(_ context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.entries[key]
+	if !ok || !now.Before(entry.expiresAt) {
+		entry = memoryEntry{expiresAt: now.Add(window)}
+	}
+	entry.count++
+	s.entries[key] = entry
+
+	return entry.count, entry.expiresAt.Sub(now), nil
+}
+
+func (s *MemoryStore) Block(_ context.Context, key string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = memoryEntry{
+		count:     1,
+		expiresAt: time.Now().Add(duration),
+	}
+	return nil
+}
+
+func (s *MemoryStore) BlockedFor(_ context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if remaining := time.Until(entry.expiresAt); ok && remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+func (s *MemoryStore) Reset(_ context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// Close stops the janitor.
+func (s *MemoryStore) Close() {
+	close(s.stop)
+}
+
+func (s *MemoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.deleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) deleteExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shangsuru/passkey-demo/config"
+)
+
+// How often the in-memory store removes expired entries.
+const janitorInterval = time.Minute
+
+// Store keeps the counters and blocks of the limiter. Entries expire on their own.
+type Store interface {
+	// Increment adds one to the counter under key, starting a new window if there is none,
+	// and returns the new count and the time until the window ends.
+	Increment(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+	// Block sets a block under key for the given duration, replacing an earlier one.
+	Block(ctx context.Context, key string, duration time.Duration) error
+	// BlockedFor returns how long the block under key lasts, or zero if there is none.
+	BlockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Reset removes the counters and blocks under the given keys.
+	Reset(ctx context.Context, keys ...string) error
+}
+
+// NewStore returns the backend selected by the rate_limit.store setting: "memory" or "redis".
+func NewStore(cfg config.RateLimitConfig, redisConfig config.RedisConfig) (Store, error) {
+	switch backend := cfg.Store; backend {
+	case "memory":
+		return NewMemoryStore(janitorInterval), nil
+	case "redis":
+		return NewRedisStore(redis.NewClient(&redis.Options{
+			Addr:     redisConfig.Address,
+			Password: redisConfig.Password,
+			DB:       redisConfig.DB,
+		})), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", backend)
+	}
+}
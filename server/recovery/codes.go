@@ -0,0 +1,64 @@
+// Package recovery generates the single-use codes that let users back into their account
+// when they lost their passkeys.
+package recovery
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// Number of codes in a set
+	Count = 10
+	// Bytes of randomness per code. With 80 bits, the unsalted hashes cannot be reversed
+	// by brute force, so no slow password hash is needed.
+	codeLength = 10
+	// Characters per group in the displayed code
+	groupLength = 4
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generate returns a new set of codes to show to the user once, and their hashes to store.
+func Generate() (codes []string, hashes []string, err error) {
+	codes = make([]string, Count)
+	hashes = make([]string, Count)
+	for i := range codes {
+		raw := make([]byte, codeLength)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		codes[i] = format(strings.ToLower(encoding.EncodeToString(raw)))
+		hashes[i] = Hash(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// Hash returns the hash under which a code is stored. Case, spaces and dashes of the
+// entered code do not matter.
+func Hash(code string) string {
+	sum := sha256.Sum256([]byte(normalize(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// format splits the code into groups for readability, e.g. abcd-efgh-ijkl-mnop.
+func format(code string) string {
+	var groups []string
+	for len(code) > groupLength {
+		groups = append(groups, code[:groupLength])
+		code = code[groupLength:]
+	}
+	return strings.Join(append(groups, code), "-")
+}
+
+func normalize(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
@@ -0,0 +1,29 @@
+package recovery
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerate(t *testing.T) {
+	codes, hashes, err := Generate()
+	assert.NoError(t, err)
+	assert.Len(t, codes, Count)
+	assert.Len(t, hashes, Count)
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`), code)
+		assert.Equal(t, Hash(code), hashes[i])
+		assert.False(t, seen[code])
+		seen[code] = true
+	}
+}
+
+func TestHash(t *testing.T) {
+	assert.Equal(t, Hash("abcd-efgh-ijkl-mnop"), Hash("ABCD EFGH IJKL MNOP"))
+	assert.Equal(t, Hash("abcd-efgh-ijkl-mnop"), Hash("abcdefghijklmnop"))
+	assert.NotEqual(t, Hash("abcd-efgh-ijkl-mnop"), Hash("abcd-efgh-ijkl-mnoq"))
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/uptrace/bun"
+)
+
+type SessionRepository struct {
+	DB *bun.DB
+}
+
+func (sr *SessionRepository) CreateLoginSession(ctx context.Context, session *model.LoginSession) error {
+	_, err := sr.DB.NewInsert().
+		Model(session).
+		Column("id", "user_id", "ip", "user_agent", "auth_method", "remember_me", "created_at", "last_seen_at", "expires_at", "reauthenticated_at", "second_factor_pending", "passkey_required").
+		Exec(ctx)
+	return err
+}
+
+func (sr *SessionRepository) FindLoginSession(ctx context.Context, id uuid.UUID) (*model.LoginSession, error) {
+	var session model.LoginSession
+	err := sr.DB.NewSelect().
+		Model(&session).
+		Where("id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// FindLoginSessionsByUserID returns the sessions the user is signed in with, leaving out logins
+// that still wait for the second factor or a new passkey. Like DeleteExpiredLoginSessions, it
+// treats sessions as expired after their absolute lifetime or when idle since before idleSince,
+// or rememberedIdleSince for remembered sessions, so they are not listed until swept.
+func (sr *SessionRepository) FindLoginSessionsByUserID(ctx context.Context, userID uuid.UUID, idleSince time.Time, rememberedIdleSince time.Time) ([]model.LoginSession, error) {
+	var sessions []model.LoginSession
+	err := sr.DB.NewSelect().
+		Model(&sessions).
+		Where("user_id = ?", userID).
+		Where("second_factor_pending = ?", false).
+		Where("passkey_required = ?", false).
+		Where("expires_at > ?", time.Now()).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.
+				Where("remember_me = ? AND last_seen_at >= ?", false, idleSince).
+				WhereOr("remember_me = ? AND last_seen_at >= ?", true, rememberedIdleSince)
+		}).
+		Order("last_seen_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (sr *SessionRepository) TouchLoginSession(ctx context.Context, id uuid.UUID, lastSeenAt time.Time) error {
+	_, err := sr.DB.NewUpdate().
+		Model((*model.LoginSession)(nil)).
+		Set("last_seen_at = ?", lastSeenAt).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// ReplaceLoginSession swaps the session with the given ID for a new one, failing with sql.ErrNoRows
+// if it was revoked in the meantime.
+func (sr *SessionRepository) ReplaceLoginSession(ctx context.Context, id uuid.UUID, session *model.LoginSession) error {
+	return sr.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.LoginSession)(nil)).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().
+			Model(session).
+			Column("id", "user_id", "ip", "user_agent", "auth_method", "remember_me", "created_at", "last_seen_at", "expires_at", "reauthenticated_at", "second_factor_pending", "passkey_required").
+			Exec(ctx)
+		return err
+	})
+}
+
+// DeleteLoginSession revokes one of the user's sessions.
+func (sr *SessionRepository) DeleteLoginSession(ctx context.Context, userID uuid.UUID, id uuid.UUID) error {
+	res, err := sr.DB.NewDelete().
+		Model((*model.LoginSession)(nil)).
+		Where("id = ?", id).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// DeleteLoginSessionsByUserID revokes all sessions of the user.
+func (sr *SessionRepository) DeleteLoginSessionsByUserID(ctx context.Context, userID uuid.UUID) (int64, error) {
+	res, err := sr.DB.NewDelete().
+		Model((*model.LoginSession)(nil)).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// DeleteOtherLoginSessions revokes all sessions of the user except the one with the given ID.
+func (sr *SessionRepository) DeleteOtherLoginSessions(ctx context.Context, userID uuid.UUID, keepID uuid.UUID) (int64, error) {
+	res, err := sr.DB.NewDelete().
+		Model((*model.LoginSession)(nil)).
+		Where("user_id = ?", userID).
+		Where("id <> ?", keepID).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// DeleteExpiredLoginSessions removes sessions past their absolute lifetime, sessions without
+// remember me that were idle since before idleSince, and remembered sessions that were idle
+// since before rememberedIdleSince.
+func (sr *SessionRepository) DeleteExpiredLoginSessions(ctx context.Context, idleSince time.Time, rememberedIdleSince time.Time) (int64, error) {
+	res, err := sr.DB.NewDelete().
+		Model((*model.LoginSession)(nil)).
+		Where("expires_at <= ?", time.Now()).
+		WhereOr("remember_me = ? AND last_seen_at < ?", false, idleSince).
+		WhereOr("remember_me = ? AND last_seen_at < ?", true, rememberedIdleSince).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
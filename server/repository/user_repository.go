@@ -2,14 +2,47 @@ package repository
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"time"
 
 	"github.com/shangsuru/passkey-demo/model"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
 	"github.com/uptrace/bun"
 )
 
+// ErrLastSignInMethod is returned when removing a credential would leave the
+// user without any way to sign in.
+var ErrLastSignInMethod = errors.New("cannot remove the last sign-in method")
+
+// ErrStaleSignCount is returned when a login with an equal or higher signature counter
+// was stored concurrently, which indicates a cloned or replayed credential.
+var ErrStaleSignCount = errors.New("signature counter is not greater than the stored value")
+
+// ErrTOTPCodeUsed is returned when a TOTP code of the same or a later period was accepted before,
+// so the code may have been observed and replayed.
+var ErrTOTPCodeUsed = errors.New("TOTP code was already used")
+
+// ErrUsernameTaken is returned when a registration is completed for a username that
+// was claimed in the meantime.
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// ErrRegistrationPending is returned when a username is reserved by a passkey registration
+// that is still in progress.
+var ErrRegistrationPending = errors.New("a registration for this username is in progress")
+
+// ErrEmailTaken is returned when an email address is verified for an account while another
+// account already has it.
+var ErrEmailTaken = errors.New("email address is already in use")
+
+// ErrEmailChanged is returned when an email address is verified with a link created before the
+// address of the account changed, including by using the same link before.
+var ErrEmailChanged = errors.New("email address changed since the verification link was created")
+
 type UserRepository struct {
 	DB *bun.DB
 }
@@ -29,6 +62,19 @@ func (ur *UserRepository) FindUserByUsername(ctx context.Context, username strin
 	return &user, nil
 }
 
+func (ur *UserRepository) FindUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	var user model.User
+	err := ur.DB.NewSelect().
+		Model(&user).
+		Where("email = ?", email).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
 func (ur *UserRepository) FindUserByID(ctx context.Context, rawUserID []byte) (*model.User, error) {
 	userID, err := uuid.FromBytes(rawUserID)
 	if err != nil {
@@ -49,14 +95,55 @@ func (ur *UserRepository) FindUserByID(ctx context.Context, rawUserID []byte) (*
 	return &user, nil
 }
 
+// CreateUser creates an account with a password. It returns ErrUsernameTaken if the username
+// belongs to another account and ErrRegistrationPending if a passkey registration reserved it.
 func (ur *UserRepository) CreateUser(ctx context.Context, username string, passwordHash string) (*model.User, error) {
+	var user *model.User
+	err := ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		taken, err := tx.NewSelect().
+			Model((*model.User)(nil)).
+			Where("username = ?", username).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrUsernameTaken
+		}
+
+		pending, err := tx.NewSelect().
+			Model((*model.PendingRegistration)(nil)).
+			Where("username = ?", username).
+			Where("expires_at > ?", time.Now()).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if pending {
+			return ErrRegistrationPending
+		}
+
+		user, err = createUser(ctx, tx, uuid.New(), username, passwordHash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func createUser(ctx context.Context, db bun.IDB, id uuid.UUID, username string, passwordHash string) (*model.User, error) {
 	user := &model.User{
-		ID:           uuid.New(),
+		ID:           id,
 		Username:     username,
 		PasswordHash: passwordHash,
 	}
 
-	_, err := ur.DB.NewInsert().Model(user).Column("id", "username", "password_hash").Returning("*").Exec(ctx, user)
+	_, err := db.NewInsert().Model(user).Column("id", "username", "password_hash").Returning("*").Exec(ctx, user)
+	if isUniqueViolation(err) {
+		return nil, ErrUsernameTaken
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -64,26 +151,411 @@ func (ur *UserRepository) CreateUser(ctx context.Context, username string, passw
 	return user, nil
 }
 
+// SetPassword sets the password of an account that does not have one yet.
+// It returns sql.ErrNoRows if the user does not exist or already has a password.
+func (ur *UserRepository) SetPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	res, err := ur.DB.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("password_hash = ?", passwordHash).
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("id = ?", userID).
+		Where("password_hash IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// ReplacePasswordHash changes the password hash of the user if it is still oldHash. It returns
+// sql.ErrNoRows if the password was changed or removed in the meantime.
+func (ur *UserRepository) ReplacePasswordHash(ctx context.Context, userID uuid.UUID, oldHash string, newHash string) error {
+	res, err := ur.DB.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("password_hash = ?", newHash).
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("id = ?", userID).
+		Where("password_hash = ?", oldHash).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// RemovePassword turns the account into a passkey-only account, refusing with
+// ErrLastSignInMethod if the user has no passkey. Pending password reset links stop working.
+func (ur *UserRepository) RemovePassword(ctx context.Context, userID uuid.UUID) error {
+	return ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Touching the user row first serializes this with concurrent passkey deletions
+		res, err := tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("updated_at = CURRENT_TIMESTAMP").
+			Where("id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+
+		count, err := tx.NewSelect().
+			Model((*model.WebauthnCredentials)(nil)).
+			Where("user_id = ?", userID).
+			Count(ctx)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrLastSignInMethod
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("password_hash = NULL").
+			Where("id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return deletePasswordResetTokens(ctx, tx, userID)
+	})
+}
+
+// SetPendingTOTPSecret stores a new TOTP secret that is not used until EnableTOTP confirms it.
+// It returns sql.ErrNoRows if the user does not exist or already has TOTP enabled.
+func (ur *UserRepository) SetPendingTOTPSecret(ctx context.Context, userID uuid.UUID, secret string) error {
+	res, err := ur.DB.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("totp_secret = ?", secret).
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("id = ?", userID).
+		Where("totp_enabled_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// EnableTOTP requires TOTP codes from now on, after the user proved with a code of the given
+// counter that they set up the secret. It returns sql.ErrNoRows if the pending secret was
+// replaced in the meantime or TOTP is already enabled.
+func (ur *UserRepository) EnableTOTP(ctx context.Context, userID uuid.UUID, secret string, counter int64) error {
+	res, err := ur.DB.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("totp_enabled_at = CURRENT_TIMESTAMP").
+		Set("totp_last_counter = ?", counter).
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("id = ?", userID).
+		Where("totp_secret = ?", secret).
+		Where("totp_enabled_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// UseTOTPCounter records that the TOTP code of the given counter was used. Like the signature
+// counter of passkeys it only moves forward, so of two logins with the same code one fails
+// with ErrTOTPCodeUsed.
+func (ur *UserRepository) UseTOTPCounter(ctx context.Context, userID uuid.UUID, counter int64) error {
+	res, err := ur.DB.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("totp_last_counter = ?", counter).
+		Where("id = ?", userID).
+		Where("totp_last_counter < ?", counter).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRowsAffected(res); errors.Is(err, sql.ErrNoRows) {
+		return ErrTOTPCodeUsed
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DisableTOTP removes the TOTP secret, so password logins no longer require a code.
+func (ur *UserRepository) DisableTOTP(ctx context.Context, userID uuid.UUID) error {
+	res, err := ur.DB.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("totp_secret = NULL").
+		Set("totp_enabled_at = NULL").
+		Set("totp_last_counter = 0").
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// SetEmail stores an email address the user just verified, replacing their previous one. version
+// is the EmailVersion of the user when the link was created; it returns ErrEmailChanged if the
+// address has changed since then, and ErrEmailTaken if another account has verified the address
+// already.
+func (ur *UserRepository) SetEmail(ctx context.Context, userID uuid.UUID, email string, version int64) error {
+	return ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var user model.User
+		err := tx.NewSelect().
+			Model(&user).
+			Column("email_version").
+			Where("id = ?", userID).
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+		if user.EmailVersion != version {
+			return ErrEmailChanged
+		}
+
+		exists, err := tx.NewSelect().
+			Model((*model.User)(nil)).
+			Where("email = ?", email).
+			Where("id != ?", userID).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrEmailTaken
+		}
+
+		res, err := tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("email = ?", email).
+			Set("email_verified_at = CURRENT_TIMESTAMP").
+			Set("email_version = email_version + 1").
+			Set("updated_at = CURRENT_TIMESTAMP").
+			Where("id = ?", userID).
+			Where("email_version = ?", version).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = checkRowsAffected(res)
+		if errors.Is(err, sql.ErrNoRows) {
+			// Another link of the same version was used concurrently
+			return ErrEmailChanged
+		}
+		if err != nil {
+			return err
+		}
+
+		// Reset links went to the previous address
+		return deletePasswordResetTokens(ctx, tx, userID)
+	})
+}
+
+// RemoveEmail removes the email address of the user, together with the password reset links sent
+// to it.
+func (ur *UserRepository) RemoveEmail(ctx context.Context, userID uuid.UUID) error {
+	return ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("email = NULL").
+			Set("email_verified_at = NULL").
+			Set("email_version = email_version + 1").
+			Set("updated_at = CURRENT_TIMESTAMP").
+			Where("id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+
+		return deletePasswordResetTokens(ctx, tx, userID)
+	})
+}
+
+// CreatePasswordResetToken stores the hash of a new password reset token of the user, replacing
+// earlier ones, so only the latest link works.
+func (ur *UserRepository) CreatePasswordResetToken(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	return ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().
+			Model((*model.PasswordResetToken)(nil)).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().
+			Model(&model.PasswordResetToken{
+				ID:        uuid.New(),
+				UserID:    userID,
+				TokenHash: tokenHash,
+				ExpiresAt: expiresAt,
+			}).
+			Column("id", "user_id", "token_hash", "expires_at").
+			Exec(ctx)
+		return err
+	})
+}
+
+// ResetPassword uses up the password reset token with the given hash and sets the password of its
+// user, returning the user's ID. It returns sql.ErrNoRows if there is no such token, it expired or
+// the user no longer has a password.
+func (ur *UserRepository) ResetPassword(ctx context.Context, tokenHash string, passwordHash string) (uuid.UUID, error) {
+	var token model.PasswordResetToken
+	err := ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		err := tx.NewSelect().
+			Model(&token).
+			Where("token_hash = ?", tokenHash).
+			Where("expires_at > ?", time.Now()).
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		// Of two concurrent resets with the same token only one deletes it
+		res, err := tx.NewDelete().
+			Model((*model.PasswordResetToken)(nil)).
+			Where("id = ?", token.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+
+		res, err = tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("password_hash = ?", passwordHash).
+			Set("updated_at = CURRENT_TIMESTAMP").
+			Where("id = ?", token.UserID).
+			// A reset must not add a password to a passkey-only account
+			Where("password_hash IS NOT NULL").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return checkRowsAffected(res)
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return token.UserID, nil
+}
+
+func deletePasswordResetTokens(ctx context.Context, db bun.IDB, userID uuid.UUID) error {
+	_, err := db.NewDelete().
+		Model((*model.PasswordResetToken)(nil)).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	return err
+}
+
 func (ur *UserRepository) DeleteUser(ctx context.Context, user *model.User) error {
 	_, err := ur.DB.NewDelete().Model(user).WherePK().Exec(ctx)
 	return err
 }
 
-func (ur *UserRepository) AddWebauthnCredential(ctx context.Context, userID uuid.UUID, credential *webauthn.Credential) error {
+// DeleteUserAccount deletes the user together with all their credentials, recovery codes and sessions and records the deletion.
+func (ur *UserRepository) DeleteUserAccount(ctx context.Context, userID uuid.UUID, method string) error {
+	return ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewDelete().
+			Model((*model.WebauthnCredentials)(nil)).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.LoginSession)(nil)).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.RecoveryCode)(nil)).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.PasswordResetToken)(nil)).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.NewDelete().
+			Model((*model.User)(nil)).
+			Where("id = ?", userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+
+		deletion := &model.AccountDeletion{
+			ID:     uuid.New(),
+			UserID: userID,
+			Method: method,
+		}
+		_, err = tx.NewInsert().
+			Model(deletion).
+			Column("id", "user_id", "method").
+			Exec(ctx)
+		return err
+	})
+}
+
+// AddWebauthnCredential stores a newly registered credential together with the attestation
+// object of its registration.
+func (ur *UserRepository) AddWebauthnCredential(ctx context.Context, userID uuid.UUID, credential *webauthn.Credential, attestationObject []byte, name string) error {
+	return addWebauthnCredential(ctx, ur.DB, userID, credential, attestationObject, name)
+}
+
+func addWebauthnCredential(ctx context.Context, db bun.IDB, userID uuid.UUID, credential *webauthn.Credential, attestationObject []byte, name string) error {
+	// A missing AAGUID is stored as NULL
+	aaguid, _ := uuid.FromBytes(credential.Authenticator.AAGUID)
+
 	newWebauthnCredential := &model.WebauthnCredentials{
-		ID:              uuid.New(),
-		UserID:          userID,
-		CredentialID:    credential.ID,
-		PublicKey:       credential.PublicKey,
-		AttestationType: credential.AttestationType,
-		Transport:       credential.Transport,
-		Flags:           credential.Flags,
-		Authenticator:   credential.Authenticator,
+		ID:                uuid.New(),
+		UserID:            userID,
+		Name:              name,
+		CredentialID:      credential.ID,
+		PublicKey:         credential.PublicKey,
+		AttestationType:   credential.AttestationType,
+		Transport:         credential.Transport,
+		Flags:             credential.Flags,
+		Authenticator:     credential.Authenticator,
+		SignCount:         credential.Authenticator.SignCount,
+		AttestationObject: attestationObject,
+		AAGUID:            aaguid,
 	}
 
-	_, err := ur.DB.NewInsert().
+	_, err := db.NewInsert().
 		Model(newWebauthnCredential).
-		Column("id", "user_id", "credential_id", "public_key", "attestation_type", "transport", "flags", "authenticator").
+		Column("id", "user_id", "name", "credential_id", "public_key", "attestation_type", "transport", "flags", "authenticator", "sign_count", "attestation_object", "aaguid").
 		Exec(ctx)
 	if err != nil {
 		return err
@@ -92,6 +564,96 @@ func (ur *UserRepository) AddWebauthnCredential(ctx context.Context, userID uuid
 	return nil
 }
 
+// UpdateWebauthnCredentialAfterLogin stores the signature counter, flags and last-used time of a
+// credential after a successful assertion. The counter only ever moves forward, so that of two
+// concurrent logins the one with the lower counter fails with ErrStaleSignCount instead of
+// overwriting the newer value. Authenticators without a counter always report zero.
+func (ur *UserRepository) UpdateWebauthnCredentialAfterLogin(ctx context.Context, credential *webauthn.Credential) error {
+	signCount := credential.Authenticator.SignCount
+
+	res, err := ur.DB.NewUpdate().
+		Model((*model.WebauthnCredentials)(nil)).
+		Set("sign_count = ?", signCount).
+		Set("authenticator = ?", credential.Authenticator).
+		Set("flags = ?", credential.Flags).
+		Set("last_used_at = CURRENT_TIMESTAMP").
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("credential_id = ?", credential.ID).
+		Where("(sign_count < ? OR ? = 0)", signCount, signCount).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := checkRowsAffected(res); errors.Is(err, sql.ErrNoRows) {
+		return ErrStaleSignCount
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes stores a new set of recovery codes for the user, invalidating the previous set.
+func (ur *UserRepository) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error {
+	return ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, hashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, db bun.IDB, userID uuid.UUID, hashes []string) error {
+	_, err := db.NewDelete().
+		Model((*model.RecoveryCode)(nil)).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(hashes) == 0 {
+		return nil
+	}
+	codes := make([]model.RecoveryCode, len(hashes))
+	for i, hash := range hashes {
+		codes[i] = model.RecoveryCode{
+			ID:       uuid.New(),
+			UserID:   userID,
+			CodeHash: hash,
+		}
+	}
+	_, err = db.NewInsert().
+		Model(&codes).
+		Column("id", "user_id", "code_hash").
+		Exec(ctx)
+	return err
+}
+
+// UseRecoveryCode marks the user's recovery code with the given hash as used. It returns
+// sql.ErrNoRows if there is no such code or it was used before.
+func (ur *UserRepository) UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) error {
+	res, err := ur.DB.NewUpdate().
+		Model((*model.RecoveryCode)(nil)).
+		Set("used_at = CURRENT_TIMESTAMP").
+		Where("user_id = ?", userID).
+		Where("code_hash = ?", hash).
+		Where("used_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// CountRecoveryCodes returns how many of the user's recovery codes are still unused.
+func (ur *UserRepository) CountRecoveryCodes(ctx context.Context, userID uuid.UUID) (int, error) {
+	return ur.DB.NewSelect().
+		Model((*model.RecoveryCode)(nil)).
+		Where("user_id = ?", userID).
+		Where("used_at IS NULL").
+		Count(ctx)
+}
+
 func (ur *UserRepository) FindUserIDByCredentialID(ctx context.Context, id []byte) (*uuid.UUID, error) {
 	var credential model.WebauthnCredentials
 	err := ur.DB.NewSelect().
@@ -105,3 +667,229 @@ func (ur *UserRepository) FindUserIDByCredentialID(ctx context.Context, id []byt
 
 	return &credential.UserID, nil
 }
+
+func (ur *UserRepository) FindWebauthnCredentialsByUserID(ctx context.Context, userID uuid.UUID) ([]model.WebauthnCredentials, error) {
+	var credentials []model.WebauthnCredentials
+	err := ur.DB.NewSelect().
+		Model(&credentials).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func (ur *UserRepository) RenameWebauthnCredential(ctx context.Context, userID uuid.UUID, id uuid.UUID, name string) error {
+	res, err := ur.DB.NewUpdate().
+		Model((*model.WebauthnCredentials)(nil)).
+		Set("name = ?", name).
+		Set("updated_at = CURRENT_TIMESTAMP").
+		Where("id = ?", id).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return checkRowsAffected(res)
+}
+
+// DeleteWebauthnCredential removes one of the user's credentials, refusing with
+// ErrLastSignInMethod if it is the only passkey of an account without a password.
+func (ur *UserRepository) DeleteWebauthnCredential(ctx context.Context, userID uuid.UUID, id uuid.UUID) error {
+	return ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// Touching the user row first serializes concurrent deletions for the same user
+		var user model.User
+		err := tx.NewUpdate().
+			Model(&user).
+			Set("updated_at = CURRENT_TIMESTAMP").
+			Where("id = ?", userID).
+			Returning("*").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		exists, err := tx.NewSelect().
+			Model((*model.WebauthnCredentials)(nil)).
+			Where("id = ?", id).
+			Where("user_id = ?", userID).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+
+		count, err := tx.NewSelect().
+			Model((*model.WebauthnCredentials)(nil)).
+			Where("user_id = ?", userID).
+			Count(ctx)
+		if err != nil {
+			return err
+		}
+		if count <= 1 && !user.HasPassword() {
+			return ErrLastSignInMethod
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.WebauthnCredentials)(nil)).
+			Where("id = ?", id).
+			Where("user_id = ?", userID).
+			Exec(ctx)
+		return err
+	})
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation of Postgres or SQLite.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+	}
+	return false
+}
+
+func checkRowsAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CreatePendingRegistration reserves the username until expiresAt. It returns
+// ErrRegistrationPending while an earlier registration for the username has not expired.
+func (ur *UserRepository) CreatePendingRegistration(ctx context.Context, username string, expiresAt time.Time) (*model.PendingRegistration, error) {
+	pending := &model.PendingRegistration{
+		ID:        uuid.New(),
+		Username:  username,
+		ExpiresAt: expiresAt,
+	}
+
+	err := ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		// An abandoned registration no longer reserves the username
+		_, err := tx.NewDelete().
+			Model((*model.PendingRegistration)(nil)).
+			Where("username = ?", username).
+			Where("expires_at <= ?", time.Now()).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().
+			Model(pending).
+			Column("id", "username", "expires_at").
+			Exec(ctx)
+		if isUniqueViolation(err) {
+			return ErrRegistrationPending
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// FindPendingRegistration returns the registration for the given WebAuthn user handle
+// if it has not expired yet.
+func (ur *UserRepository) FindPendingRegistration(ctx context.Context, rawUserID []byte) (*model.PendingRegistration, error) {
+	id, err := uuid.FromBytes(rawUserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending model.PendingRegistration
+	err = ur.DB.NewSelect().
+		Model(&pending).
+		Where("id = ?", id).
+		Where("expires_at > ?", time.Now()).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pending, nil
+}
+
+// DeletePendingRegistration releases the username reserved by the registration, if it still exists.
+func (ur *UserRepository) DeletePendingRegistration(ctx context.Context, id uuid.UUID) error {
+	_, err := ur.DB.NewDelete().
+		Model((*model.PendingRegistration)(nil)).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// CompletePendingRegistration creates the user together with their first credential and recovery
+// codes and removes the pending registration, all in one transaction.
+func (ur *UserRepository) CompletePendingRegistration(ctx context.Context, pending *model.PendingRegistration, passwordHash string, credential *webauthn.Credential, attestationObject []byte, credentialName string, recoveryCodeHashes []string) (*model.User, error) {
+	var user *model.User
+	err := ur.DB.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.PendingRegistration)(nil)).
+			Where("id = ?", pending.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if err := checkRowsAffected(res); err != nil {
+			return err
+		}
+
+		exists, err := tx.NewSelect().
+			Model((*model.User)(nil)).
+			Where("username = ?", pending.Username).
+			Exists(ctx)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrUsernameTaken
+		}
+
+		user, err = createUser(ctx, tx, pending.ID, pending.Username, passwordHash)
+		if err != nil {
+			return err
+		}
+
+		if err := addWebauthnCredential(ctx, tx, user.ID, credential, attestationObject, credentialName); err != nil {
+			return err
+		}
+
+		return replaceRecoveryCodes(ctx, tx, user.ID, recoveryCodeHashes)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// DeleteExpiredPendingRegistrations removes abandoned registrations and returns how many were removed.
+func (ur *UserRepository) DeleteExpiredPendingRegistrations(ctx context.Context) (int64, error) {
+	res, err := ur.DB.NewDelete().
+		Model((*model.PendingRegistration)(nil)).
+		Where("expires_at <= ?", time.Now()).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
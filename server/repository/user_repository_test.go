@@ -0,0 +1,383 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/shangsuru/passkey-demo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserRepository_UpdateWebauthnCredentialAfterLogin(t *testing.T) {
+	ctx := context.Background()
+	database := db.GetTestDB()
+	defer database.Close()
+	userRepository := UserRepository{DB: database}
+
+	user, err := userRepository.CreateUser(ctx, "sign_count_user", "")
+	assert.NoError(t, err)
+
+	credential := &webauthn.Credential{
+		ID:              []byte("credential"),
+		PublicKey:       []byte("public key"),
+		AttestationType: "none",
+		Authenticator:   webauthn.Authenticator{SignCount: 1},
+	}
+	assert.NoError(t, userRepository.AddWebauthnCredential(ctx, user.ID, credential, nil, "Passkey"))
+
+	t.Run("stores the new counter, flags and last-used time", func(t *testing.T) {
+		credential.Authenticator.SignCount = 5
+		credential.Flags.BackupState = true
+		assert.NoError(t, userRepository.UpdateWebauthnCredentialAfterLogin(ctx, credential))
+
+		user, err := userRepository.FindUserByID(ctx, user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(5), user.WebAuthnCredentials()[0].Authenticator.SignCount)
+		assert.True(t, user.WebAuthnCredentials()[0].Flags.BackupState)
+		assert.NotNil(t, user.WebauthnCredentials[0].LastUsedAt)
+	})
+
+	t.Run("rejects a counter that is not greater than the stored one", func(t *testing.T) {
+		credential.Authenticator.SignCount = 4
+		assert.ErrorIs(t, userRepository.UpdateWebauthnCredentialAfterLogin(ctx, credential), ErrStaleSignCount)
+
+		user, err := userRepository.FindUserByID(ctx, user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(5), user.WebAuthnCredentials()[0].Authenticator.SignCount)
+	})
+}
+
+func TestUserRepository_AddWebauthnCredential(t *testing.T) {
+	ctx := context.Background()
+	database := db.GetTestDB()
+	defer database.Close()
+	userRepository := UserRepository{DB: database}
+
+	user, err := userRepository.CreateUser(ctx, "attestation_user", "")
+	assert.NoError(t, err)
+
+	aaguid := uuid.MustParse("fbfc3007-154e-4ecc-8c0b-6e020557d7bd")
+	assert.NoError(t, userRepository.AddWebauthnCredential(ctx, user.ID, &webauthn.Credential{
+		ID:              []byte("attested credential"),
+		PublicKey:       []byte("public key"),
+		AttestationType: "packed",
+		Authenticator:   webauthn.Authenticator{AAGUID: aaguid[:]},
+	}, []byte("attestation object"), "iCloud Keychain"))
+	assert.NoError(t, userRepository.AddWebauthnCredential(ctx, user.ID, &webauthn.Credential{
+		ID:              []byte("credential without aaguid"),
+		PublicKey:       []byte("public key"),
+		AttestationType: "none",
+	}, nil, "Chrome on Mac"))
+
+	user, err = userRepository.FindUserByID(ctx, user.WebAuthnID())
+	assert.NoError(t, err)
+	assert.Len(t, user.WebauthnCredentials, 2)
+	for _, credential := range user.WebauthnCredentials {
+		switch string(credential.CredentialID) {
+		case "attested credential":
+			assert.Equal(t, "iCloud Keychain", credential.Name)
+			assert.Equal(t, "packed", credential.AttestationType)
+			assert.Equal(t, []byte("attestation object"), credential.AttestationObject)
+			assert.Equal(t, aaguid, credential.AAGUID)
+		default:
+			assert.Equal(t, "none", credential.AttestationType)
+			assert.Nil(t, credential.AttestationObject)
+			assert.Equal(t, uuid.Nil, credential.AAGUID)
+		}
+	}
+}
+
+func TestUserRepository_PendingRegistration(t *testing.T) {
+	ctx := context.Background()
+	database := db.GetTestDB()
+	defer database.Close()
+	userRepository := UserRepository{DB: database}
+
+	credential := &webauthn.Credential{
+		ID:              []byte("first credential"),
+		PublicKey:       []byte("public key"),
+		AttestationType: "none",
+	}
+
+	t.Run("creates the user with their first credential", func(t *testing.T) {
+		pending, err := userRepository.CreatePendingRegistration(ctx, "pending_user", time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		// The username is not taken before the ceremony completes
+		_, err = userRepository.FindUserByUsername(ctx, "pending_user")
+		assert.Error(t, err)
+
+		found, err := userRepository.FindPendingRegistration(ctx, pending.User().WebAuthnID())
+		assert.NoError(t, err)
+
+		user, err := userRepository.CompletePendingRegistration(ctx, found, "", credential, nil, "Passkey", []string{"first hash", "second hash"})
+		assert.NoError(t, err)
+		assert.Equal(t, pending.ID, user.ID)
+
+		count, err := userRepository.CountRecoveryCodes(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		user, err = userRepository.FindUserByUsername(ctx, "pending_user")
+		assert.NoError(t, err)
+		assert.Len(t, user.WebauthnCredentials, 1)
+
+		// A registration can only be completed once
+		_, err = userRepository.CompletePendingRegistration(ctx, found, "", credential, nil, "Passkey", nil)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("rejects a username taken in the meantime", func(t *testing.T) {
+		pending, err := userRepository.CreatePendingRegistration(ctx, "raced_user", time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+		_, err = createUser(ctx, database, uuid.New(), "raced_user", "hash")
+		assert.NoError(t, err)
+
+		_, err = userRepository.CompletePendingRegistration(ctx, pending, "", credential, nil, "Passkey", nil)
+		assert.ErrorIs(t, err, ErrUsernameTaken)
+	})
+
+	t.Run("reserves the username until it expires", func(t *testing.T) {
+		_, err := userRepository.CreatePendingRegistration(ctx, "reserved_user", time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+
+		_, err = userRepository.CreatePendingRegistration(ctx, "reserved_user", time.Now().Add(time.Minute))
+		assert.ErrorIs(t, err, ErrRegistrationPending)
+		_, err = userRepository.CreateUser(ctx, "reserved_user", "hash")
+		assert.ErrorIs(t, err, ErrRegistrationPending)
+
+		_, err = userRepository.CreatePendingRegistration(ctx, "expiring_user", time.Now().Add(-time.Second))
+		assert.NoError(t, err)
+		_, err = userRepository.CreatePendingRegistration(ctx, "expiring_user", time.Now().Add(time.Minute))
+		assert.NoError(t, err)
+	})
+
+	t.Run("maps a duplicate username to ErrUsernameTaken", func(t *testing.T) {
+		_, err := userRepository.CreateUser(ctx, "duplicate_user", "hash")
+		assert.NoError(t, err)
+
+		_, err = userRepository.CreateUser(ctx, "duplicate_user", "hash")
+		assert.ErrorIs(t, err, ErrUsernameTaken)
+		// Bypassing the check, the unique index rejects it
+		_, err = createUser(ctx, database, uuid.New(), "duplicate_user", "hash")
+		assert.ErrorIs(t, err, ErrUsernameTaken)
+	})
+
+	t.Run("sweeps expired registrations", func(t *testing.T) {
+		expired, err := userRepository.CreatePendingRegistration(ctx, "abandoned_user", time.Now().Add(-time.Minute))
+		assert.NoError(t, err)
+
+		_, err = userRepository.FindPendingRegistration(ctx, expired.User().WebAuthnID())
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		n, err := userRepository.DeleteExpiredPendingRegistrations(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n)
+	})
+}
+
+func TestUserRepository_TOTP(t *testing.T) {
+	ctx := context.Background()
+	database := db.GetTestDB()
+	defer database.Close()
+	userRepository := UserRepository{DB: database}
+
+	user, err := userRepository.CreateUser(ctx, "totp_user", "hash")
+	assert.NoError(t, err)
+
+	t.Run("a pending secret is only enabled if it was not replaced", func(t *testing.T) {
+		assert.NoError(t, userRepository.SetPendingTOTPSecret(ctx, user.ID, "FIRST"))
+		assert.NoError(t, userRepository.SetPendingTOTPSecret(ctx, user.ID, "SECOND"))
+
+		assert.ErrorIs(t, userRepository.EnableTOTP(ctx, user.ID, "FIRST", 100), sql.ErrNoRows)
+		assert.NoError(t, userRepository.EnableTOTP(ctx, user.ID, "SECOND", 100))
+
+		user, err := userRepository.FindUserByID(ctx, user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.True(t, user.TOTPEnabled())
+		assert.Equal(t, "SECOND", user.TOTPSecret)
+		assert.Equal(t, int64(100), user.TOTPLastCounter)
+
+		// An enabled secret cannot be replaced without disabling it first
+		assert.ErrorIs(t, userRepository.SetPendingTOTPSecret(ctx, user.ID, "THIRD"), sql.ErrNoRows)
+	})
+
+	t.Run("codes cannot be used twice", func(t *testing.T) {
+		assert.ErrorIs(t, userRepository.UseTOTPCounter(ctx, user.ID, 100), ErrTOTPCodeUsed)
+		assert.NoError(t, userRepository.UseTOTPCounter(ctx, user.ID, 101))
+		assert.ErrorIs(t, userRepository.UseTOTPCounter(ctx, user.ID, 101), ErrTOTPCodeUsed)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		assert.NoError(t, userRepository.DisableTOTP(ctx, user.ID))
+
+		user, err := userRepository.FindUserByID(ctx, user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.False(t, user.TOTPEnabled())
+		assert.Empty(t, user.TOTPSecret)
+	})
+}
+
+func TestUserRepository_Email(t *testing.T) {
+	ctx := context.Background()
+	database := db.GetTestDB()
+	defer database.Close()
+	userRepository := UserRepository{DB: database}
+
+	alice, err := userRepository.CreateUser(ctx, "email_alice", "hash")
+	assert.NoError(t, err)
+	bob, err := userRepository.CreateUser(ctx, "email_bob", "hash")
+	assert.NoError(t, err)
+
+	assert.NoError(t, userRepository.SetEmail(ctx, alice.ID, "alice@example.com", 0))
+	user, err := userRepository.FindUserByID(ctx, alice.WebAuthnID())
+	assert.NoError(t, err)
+	assert.Equal(t, "alice@example.com", user.Email)
+	assert.NotNil(t, user.EmailVerifiedAt)
+	assert.Equal(t, int64(1), user.EmailVersion)
+
+	// A link only works once
+	assert.ErrorIs(t, userRepository.SetEmail(ctx, alice.ID, "alice@example.com", 0), ErrEmailChanged)
+
+	// Verifying the same address again is fine, for another account it is not
+	assert.NoError(t, userRepository.SetEmail(ctx, alice.ID, "alice@example.com", 1))
+	assert.ErrorIs(t, userRepository.SetEmail(ctx, bob.ID, "alice@example.com", 0), ErrEmailTaken)
+
+	assert.NoError(t, userRepository.RemoveEmail(ctx, alice.ID))
+	user, err = userRepository.FindUserByID(ctx, alice.WebAuthnID())
+	assert.NoError(t, err)
+	assert.Empty(t, user.Email)
+	assert.Nil(t, user.EmailVerifiedAt)
+	assert.ErrorIs(t, userRepository.SetEmail(ctx, alice.ID, "alice@example.net", 2), ErrEmailChanged)
+
+	assert.NoError(t, userRepository.SetEmail(ctx, bob.ID, "alice@example.com", 0))
+	assert.ErrorIs(t, userRepository.SetEmail(ctx, uuid.New(), "nobody@example.com", 0), sql.ErrNoRows)
+}
+
+func TestUserRepository_PasswordReset(t *testing.T) {
+	ctx := context.Background()
+	database := db.GetTestDB()
+	defer database.Close()
+	userRepository := UserRepository{DB: database}
+
+	user, err := userRepository.CreateUser(ctx, "reset_user", "old")
+	assert.NoError(t, err)
+
+	t.Run("only the latest token works, and only once", func(t *testing.T) {
+		assert.NoError(t, userRepository.CreatePasswordResetToken(ctx, user.ID, "first", time.Now().Add(time.Hour)))
+		assert.NoError(t, userRepository.CreatePasswordResetToken(ctx, user.ID, "second", time.Now().Add(time.Hour)))
+
+		_, err := userRepository.ResetPassword(ctx, "first", "new")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		userID, err := userRepository.ResetPassword(ctx, "second", "new")
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, userID)
+
+		user, err := userRepository.FindUserByID(ctx, user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.Equal(t, "new", user.PasswordHash)
+
+		_, err = userRepository.ResetPassword(ctx, "second", "newer")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		assert.NoError(t, userRepository.CreatePasswordResetToken(ctx, user.ID, "expired", time.Now().Add(-time.Second)))
+
+		_, err := userRepository.ResetPassword(ctx, "expired", "newer")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("changing or removing the email address", func(t *testing.T) {
+		assert.NoError(t, userRepository.SetEmail(ctx, user.ID, "reset@example.com", 0))
+		assert.NoError(t, userRepository.CreatePasswordResetToken(ctx, user.ID, "before change", time.Now().Add(time.Hour)))
+		assert.NoError(t, userRepository.SetEmail(ctx, user.ID, "reset@example.net", 1))
+		_, err := userRepository.ResetPassword(ctx, "before change", "newer")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		assert.NoError(t, userRepository.CreatePasswordResetToken(ctx, user.ID, "before removal", time.Now().Add(time.Hour)))
+		assert.NoError(t, userRepository.RemoveEmail(ctx, user.ID))
+		_, err = userRepository.ResetPassword(ctx, "before removal", "newer")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("removing the password", func(t *testing.T) {
+		user, err := userRepository.CreateUser(ctx, "reset_remove_password_user", "old")
+		assert.NoError(t, err)
+		assert.NoError(t, userRepository.AddWebauthnCredential(ctx, user.ID, &webauthn.Credential{
+			ID:              []byte("reset credential"),
+			PublicKey:       []byte("public key"),
+			AttestationType: "none",
+		}, nil, "Passkey"))
+
+		assert.NoError(t, userRepository.CreatePasswordResetToken(ctx, user.ID, "before password removal", time.Now().Add(time.Hour)))
+		assert.NoError(t, userRepository.RemovePassword(ctx, user.ID))
+		_, err = userRepository.ResetPassword(ctx, "before password removal", "new")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		// Nor does a token that is left over add a password
+		assert.NoError(t, userRepository.CreatePasswordResetToken(ctx, user.ID, "passkey-only", time.Now().Add(time.Hour)))
+		_, err = userRepository.ResetPassword(ctx, "passkey-only", "new")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		user, err = userRepository.FindUserByID(ctx, user.WebAuthnID())
+		assert.NoError(t, err)
+		assert.False(t, user.HasPassword())
+	})
+}
+
+func TestUserRepository_ReplacePasswordHash(t *testing.T) {
+	ctx := context.Background()
+	database := db.GetTestDB()
+	defer database.Close()
+	userRepository := UserRepository{DB: database}
+
+	user, err := userRepository.CreateUser(ctx, "replace_hash_user", "old")
+	assert.NoError(t, err)
+
+	assert.NoError(t, userRepository.ReplacePasswordHash(ctx, user.ID, "old", "new"))
+	// The hash no longer matches, so a concurrent change is not overwritten
+	assert.ErrorIs(t, userRepository.ReplacePasswordHash(ctx, user.ID, "old", "other"), sql.ErrNoRows)
+
+	user, err = userRepository.FindUserByID(ctx, user.WebAuthnID())
+	assert.NoError(t, err)
+	assert.Equal(t, "new", user.PasswordHash)
+}
+
+func TestUserRepository_RecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	database := db.GetTestDB()
+	defer database.Close()
+	userRepository := UserRepository{DB: database}
+
+	user, err := userRepository.CreateUser(ctx, "recovery_user", "")
+	assert.NoError(t, err)
+	assert.NoError(t, userRepository.ReplaceRecoveryCodes(ctx, user.ID, []string{"old hash"}))
+
+	t.Run("replacing invalidates the previous codes", func(t *testing.T) {
+		assert.NoError(t, userRepository.ReplaceRecoveryCodes(ctx, user.ID, []string{"first hash", "second hash"}))
+
+		assert.ErrorIs(t, userRepository.UseRecoveryCode(ctx, user.ID, "old hash"), sql.ErrNoRows)
+		count, err := userRepository.CountRecoveryCodes(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+	})
+
+	t.Run("codes can only be used once", func(t *testing.T) {
+		assert.NoError(t, userRepository.UseRecoveryCode(ctx, user.ID, "first hash"))
+		assert.ErrorIs(t, userRepository.UseRecoveryCode(ctx, user.ID, "first hash"), sql.ErrNoRows)
+
+		count, err := userRepository.CountRecoveryCodes(ctx, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+
+	t.Run("codes of other users do not work", func(t *testing.T) {
+		assert.ErrorIs(t, userRepository.UseRecoveryCode(ctx, uuid.New(), "second hash"), sql.ErrNoRows)
+	})
+}
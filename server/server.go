@@ -1,27 +1,126 @@
 package main
 
 import (
+	"context"
 	"embed"
-	"os"
+	"time"
 
 	"github.com/gorilla/sessions"
 	"github.com/labstack/echo-contrib/session"
 	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/config"
 	"github.com/shangsuru/passkey-demo/handler"
+	"github.com/shangsuru/passkey-demo/mds"
 	"github.com/shangsuru/passkey-demo/middleware"
+	"github.com/shangsuru/passkey-demo/repository"
+	"github.com/shangsuru/passkey-demo/sessionstore"
+)
+
+const (
+	pendingRegistrationSweepInterval = time.Minute
+	loginSessionSweepInterval        = 10 * time.Minute
+	webAuthnSessionSweepInterval     = time.Minute
 )
 
 type Server struct {
-	router             *echo.Echo
-	webAuthnController handler.WebAuthnController
-	passwordController handler.PasswordController
+	config              *config.Config
+	router              *echo.Echo
+	auth                middleware.Auth
+	reauth              middleware.Reauth
+	rateLimit           middleware.RateLimit
+	admin               middleware.Admin
+	topOrigin           middleware.TopOrigin
+	csrf                middleware.CSRF
+	webAuthnController  handler.WebAuthnController
+	passwordController  handler.PasswordController
+	passkeyController   handler.PasskeyController
+	accountController   handler.AccountController
+	sessionController   handler.SessionController
+	reauthController    handler.ReauthController
+	totpController      handler.TOTPController
+	recoveryController  handler.RecoveryController
+	emailController     handler.EmailController
+	adminController     handler.AdminController
+	wellKnownController handler.WellKnownController
+	userRepository      repository.UserRepository
+	sessionRepository   repository.SessionRepository
+	sessionStore        sessionstore.SessionStore
+	metadata            *mds.Service
 }
 
 func (s *Server) Start() {
+	s.router.IPExtractor = middleware.ClientIP(s.config.Server)
 
-	s.router.Use(session.Middleware(sessions.NewCookieStore([]byte(os.Getenv("SESSION_SECRET")))))
+	s.router.Use(s.csrf.Handle)
+	s.router.Use(session.Middleware(sessions.NewCookieStore([]byte(s.config.Session.Secret))))
 	s.registerEndpoints()
-	s.router.Logger.Fatal(s.router.Start(":9044"))
+	go s.sweepPendingRegistrations()
+	go s.sweepLoginSessions()
+	if sweeper, ok := s.sessionStore.(sessionstore.Sweeper); ok {
+		go s.sweepWebAuthnSessions(sweeper)
+	}
+	if s.metadata != nil {
+		go s.refreshMetadata()
+	}
+	s.router.Logger.Fatal(s.router.Start(s.config.Server.Address))
+}
+
+// sweepPendingRegistrations periodically removes registrations whose ceremony was abandoned.
+func (s *Server) sweepPendingRegistrations() {
+	for range time.Tick(pendingRegistrationSweepInterval) {
+		n, err := s.userRepository.DeleteExpiredPendingRegistrations(context.Background())
+		if err != nil {
+			s.router.Logger.Errorf("failed to sweep pending registrations: %v", err)
+			continue
+		}
+		if n > 0 {
+			s.router.Logger.Infof("removed %d expired pending registrations", n)
+		}
+	}
+}
+
+// sweepLoginSessions periodically removes sessions that timed out without being used again.
+func (s *Server) sweepLoginSessions() {
+	for range time.Tick(loginSessionSweepInterval) {
+		now := time.Now()
+		n, err := s.sessionRepository.DeleteExpiredLoginSessions(context.Background(), now.Add(-s.config.Session.IdleTimeout), now.Add(-s.config.Session.RememberMeIdleTimeout))
+		if err != nil {
+			s.router.Logger.Errorf("failed to sweep login sessions: %v", err)
+			continue
+		}
+		if n > 0 {
+			s.router.Logger.Infof("removed %d expired login sessions", n)
+		}
+	}
+}
+
+// sweepWebAuthnSessions periodically removes the state of ceremonies that were never finished,
+// for session stores that do not expire it by themselves.
+func (s *Server) sweepWebAuthnSessions(sweeper sessionstore.Sweeper) {
+	for range time.Tick(webAuthnSessionSweepInterval) {
+		n, err := sweeper.DeleteExpired(context.Background())
+		if err != nil {
+			s.router.Logger.Errorf("failed to sweep webauthn sessions: %v", err)
+			continue
+		}
+		if n > 0 {
+			s.router.Logger.Infof("removed %d expired webauthn sessions", n)
+		}
+	}
+}
+
+// refreshMetadata periodically reads the metadata blob again, so a newer one can be dropped in.
+func (s *Server) refreshMetadata() {
+	for range time.Tick(s.config.Metadata.RefreshInterval) {
+		number := s.metadata.Number()
+		if err := s.metadata.Load(); err != nil {
+			s.router.Logger.Errorf("failed to refresh metadata: %v", err)
+			continue
+		}
+		if s.metadata.Number() != number {
+			s.router.Logger.Infof("loaded metadata blob %d", s.metadata.Number())
+		}
+	}
 }
 
 var (
@@ -35,20 +134,56 @@ var (
 )
 
 func (s *Server) registerEndpoints() {
-	s.router.POST("/register/begin", s.webAuthnController.BeginRegistration())
-	s.router.POST("/register/finish", s.webAuthnController.FinishRegistration())
-	s.router.POST("/login/begin", s.webAuthnController.BeginLogin())
-	s.router.POST("/login/finish", s.webAuthnController.FinishLogin())
-	s.router.POST("/discoverable_login/begin", s.webAuthnController.BeginDiscoverableLogin())
-	s.router.POST("/discoverable_login/finish", s.webAuthnController.FinishDiscoverableLogin())
-	s.router.POST("/register/password", s.passwordController.SignUp())
-	s.router.POST("/login/password", s.passwordController.Login())
+	s.router.POST("/register/begin", s.webAuthnController.BeginRegistration(), s.rateLimit.Handle)
+	s.router.POST("/register/finish", s.webAuthnController.FinishRegistration(), s.topOrigin.Handle)
+	s.router.POST("/login/begin", s.webAuthnController.BeginLogin(), s.rateLimit.Handle)
+	s.router.POST("/login/finish", s.webAuthnController.FinishLogin(), s.topOrigin.Handle)
+	s.router.POST("/discoverable_login/begin", s.webAuthnController.BeginDiscoverableLogin(), s.rateLimit.Handle)
+	s.router.POST("/discoverable_login/finish", s.webAuthnController.FinishDiscoverableLogin(), s.topOrigin.Handle)
+	s.router.POST("/register/password", s.passwordController.SignUp(), s.rateLimit.Handle)
+	s.router.POST("/login/password", s.passwordController.Login(), s.rateLimit.Handle)
+	s.router.POST("/password/reset/request", s.passwordController.RequestPasswordReset(), s.rateLimit.Handle)
+	s.router.POST("/password/reset", s.passwordController.ResetPassword(), s.rateLimit.Handle)
+	s.router.POST("/login/totp", s.totpController.LoginWithTOTP(), s.rateLimit.Handle)
+	s.router.POST("/login/recovery", s.recoveryController.LoginWithRecoveryCode(), s.rateLimit.Handle)
+	s.router.POST("/recovery/passkey/begin", s.webAuthnController.BeginRecoverPasskey())
+	s.router.POST("/recovery/passkey/finish", s.webAuthnController.FinishRecoverPasskey(), s.topOrigin.Handle)
 	s.router.POST("/logout", s.passwordController.Logout())
+	s.router.POST("/reauth/begin", s.reauthController.BeginReauth())
+	s.router.POST("/reauth/finish", s.reauthController.FinishReauth(), s.topOrigin.Handle)
+	s.router.POST("/reauth/password", s.reauthController.ReauthWithPassword(), s.rateLimit.Handle)
+	s.router.POST("/api/passkeys/register/begin", s.webAuthnController.BeginAddPasskey(), s.auth.Handle, s.reauth.Handle)
+	s.router.POST("/api/passkeys/register/finish", s.webAuthnController.FinishAddPasskey(), s.auth.Handle, s.topOrigin.Handle)
+	s.router.GET("/api/passkeys", s.passkeyController.ListPasskeys(), s.auth.Handle)
+	s.router.PATCH("/api/passkeys/:id", s.passkeyController.RenamePasskey(), s.auth.Handle)
+	s.router.DELETE("/api/passkeys/:id", s.passkeyController.DeletePasskey(), s.auth.Handle, s.reauth.Handle)
+	s.router.POST("/api/account/password", s.passwordController.SetPassword(), s.auth.Handle, s.reauth.Handle)
+	s.router.PUT("/api/account/password", s.passwordController.ChangePassword(), s.auth.Handle)
+	s.router.DELETE("/api/account/password", s.passwordController.RemovePassword(), s.auth.Handle, s.reauth.Handle)
+	s.router.POST("/api/account/totp/begin", s.totpController.BeginEnrollTOTP(), s.auth.Handle, s.reauth.Handle)
+	s.router.POST("/api/account/totp/finish", s.totpController.FinishEnrollTOTP(), s.auth.Handle)
+	s.router.DELETE("/api/account/totp", s.totpController.DisableTOTP(), s.auth.Handle, s.reauth.Handle)
+	s.router.GET("/api/account/recovery_codes", s.recoveryController.CountRecoveryCodes(), s.auth.Handle)
+	s.router.POST("/api/account/recovery_codes", s.recoveryController.RegenerateRecoveryCodes(), s.auth.Handle, s.reauth.Handle)
+	s.router.GET("/api/account/email", s.emailController.GetEmail(), s.auth.Handle)
+	s.router.PUT("/api/account/email", s.emailController.ChangeEmail(), s.auth.Handle, s.reauth.Handle, s.rateLimit.Handle)
+	s.router.DELETE("/api/account/email", s.emailController.RemoveEmail(), s.auth.Handle, s.reauth.Handle)
+	s.router.POST("/email/verify", s.emailController.VerifyEmail())
+	s.router.POST("/api/account/delete/begin", s.accountController.BeginDeleteAccount(), s.auth.Handle)
+	s.router.POST("/api/account/delete/finish", s.accountController.FinishDeleteAccount(), s.auth.Handle, s.topOrigin.Handle)
+	s.router.POST("/api/account/delete/password", s.accountController.DeleteAccountWithPassword(), s.auth.Handle, s.rateLimit.Handle)
+	s.router.GET("/api/sessions", s.sessionController.ListSessions(), s.auth.Handle)
+	s.router.DELETE("/api/sessions", s.sessionController.RevokeOtherSessions(), s.auth.Handle)
+	s.router.DELETE("/api/sessions/:id", s.sessionController.RevokeSession(), s.auth.Handle)
+	s.router.POST("/admin/unlock", s.adminController.UnlockAccount(), s.admin.Handle)
+	s.router.GET("/.well-known/webauthn", s.wellKnownController.RelatedOrigins())
 
 	s.router.FileFS("/", "index.html", distIndexHtml)
 	s.router.FileFS("/sign-up", "index.html", distIndexHtml)
-	s.router.FileFS("/home", "index.html", distIndexHtml, middleware.Auth)
-	s.router.FileFS("/passkeys", "index.html", distIndexHtml, middleware.Auth)
-	s.router.FileFS("/delete_account", "index.html", distIndexHtml, middleware.Auth)
+	s.router.FileFS("/home", "index.html", distIndexHtml, s.auth.Handle)
+	s.router.FileFS("/passkeys", "index.html", distIndexHtml, s.auth.Handle)
+	s.router.FileFS("/delete_account", "index.html", distIndexHtml, s.auth.Handle)
+	s.router.FileFS(handler.EmailVerificationPath, "index.html", distIndexHtml)
+	s.router.FileFS(handler.PasswordResetPath, "index.html", distIndexHtml)
 	s.router.StaticFS("/", distDirFS)
 }
@@ -0,0 +1,104 @@
+package sessionstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// MemoryStore keeps sessions in process memory. It is meant for development, tests
+// and single-instance deployments, as sessions are neither shared nor persisted.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	stop    chan struct{}
+}
+
+// NewMemoryStore returns a store that removes expired entries every janitorInterval.
+func NewMemoryStore(janitorInterval time.Duration) *MemoryStore {
+	s := &MemoryStore{
+		entries: make(map[string]memoryEntry),
+		stop:    make(chan struct{}),
+	}
+	go s.janitor(janitorInterval)
+
+	return s
+}
+
+func (s *MemoryStore) Create(_ context.Context, id string, data []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[id] = memoryEntry{
+		data:      data,
+		expiresAt: time.Now().Add(ttl),
+	}
+	return nil
+}
+
+func (s *MemoryStore) Get(_ context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return nil, ErrNotFound
+	}
+	return entry.data, nil
+}
+
+func (s *MemoryStore) GetAndDelete(_ context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	delete(s.entries, id)
+	if !ok || !time.Now().Before(entry.expiresAt) {
+		return nil, ErrNotFound
+	}
+	return entry.data, nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, id)
+	return nil
+}
+
+// Close stops the janitor.
+func (s *MemoryStore) Close() {
+	close(s.stop)
+}
+
+func (s *MemoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.deleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *MemoryStore) deleteExpired() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, id)
+		}
+	}
+}
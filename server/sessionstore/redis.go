@@ -0,0 +1,43 @@
+package sessionstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+func (s *RedisStore) Create(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, id, data, ttl).Err()
+}
+
+func (s *RedisStore) Get(ctx context.Context, id string) ([]byte, error) {
+	data, err := s.client.Get(ctx, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+func (s *RedisStore) GetAndDelete(ctx context.Context, id string) ([]byte, error) {
+	data, err := s.client.GetDel(ctx, id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return s.client.Del(ctx, id).Err()
+}
@@ -0,0 +1,94 @@
+package sessionstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shangsuru/passkey-demo/model"
+	"github.com/uptrace/bun"
+)
+
+// SQLStore keeps sessions in the webauthn_sessions table, so they are shared by all
+// instances using the same database. Expired rows stay until DeleteExpired removes them.
+type SQLStore struct {
+	db *bun.DB
+}
+
+func NewSQLStore(db *bun.DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+func (s *SQLStore) Create(ctx context.Context, id string, data []byte, ttl time.Duration) error {
+	session := &model.WebauthnSession{
+		ID:        id,
+		Data:      data,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	_, err := s.db.NewInsert().
+		Model(session).
+		Column("id", "data", "expires_at").
+		Exec(ctx)
+	return err
+}
+
+func (s *SQLStore) Get(ctx context.Context, id string) ([]byte, error) {
+	var session model.WebauthnSession
+	err := s.db.NewSelect().
+		Model(&session).
+		Where("id = ?", id).
+		Where("expires_at > ?", time.Now()).
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return session.Data, nil
+}
+
+func (s *SQLStore) GetAndDelete(ctx context.Context, id string) ([]byte, error) {
+	// DELETE ... RETURNING makes sure only one of several concurrent callers gets the data
+	var session model.WebauthnSession
+	err := s.db.NewDelete().
+		Model(&session).
+		Where("id = ?", id).
+		Returning("data, expires_at").
+		Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return session.Data, nil
+}
+
+func (s *SQLStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.NewDelete().
+		Model((*model.WebauthnSession)(nil)).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// DeleteExpired removes expired sessions and returns how many were removed.
+func (s *SQLStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := s.db.NewDelete().
+		Model((*model.WebauthnSession)(nil)).
+		Where("expires_at <= ?", time.Now()).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
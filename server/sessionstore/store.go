@@ -0,0 +1,56 @@
+package sessionstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/uptrace/bun"
+)
+
+// How often the in-memory store removes expired entries.
+const janitorInterval = time.Minute
+
+// ErrNotFound is returned when a session does not exist or has expired.
+var ErrNotFound = errors.New("session not found")
+
+// SessionStore keeps short-lived WebAuthn ceremony state between the begin and finish requests.
+type SessionStore interface {
+	// Create stores data under id until ttl elapses.
+	Create(ctx context.Context, id string, data []byte, ttl time.Duration) error
+	// Get returns the data stored under id, or ErrNotFound.
+	Get(ctx context.Context, id string) ([]byte, error)
+	// GetAndDelete atomically returns and removes the data stored under id, so that it
+	// can be retrieved at most once. It returns ErrNotFound if there is no data.
+	GetAndDelete(ctx context.Context, id string) ([]byte, error)
+	// Delete removes the data stored under id. Deleting a missing id is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// Sweeper is implemented by stores that leave removing expired sessions to the caller.
+type Sweeper interface {
+	// DeleteExpired removes expired sessions and returns how many were removed.
+	DeleteExpired(ctx context.Context) (int64, error)
+}
+
+// NewSessionStore returns the backend selected by the session.store setting:
+// "memory", "redis" or "sql".
+func NewSessionStore(cfg config.SessionConfig, redisConfig config.RedisConfig, db *bun.DB) (SessionStore, error) {
+	switch backend := cfg.Store; backend {
+	case "memory":
+		return NewMemoryStore(janitorInterval), nil
+	case "redis":
+		return NewRedisStore(redis.NewClient(&redis.Options{
+			Addr:     redisConfig.Address,
+			Password: redisConfig.Password,
+			DB:       redisConfig.DB,
+		})), nil
+	case "sql":
+		return NewSQLStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown session store %q", backend)
+	}
+}
@@ -0,0 +1,94 @@
+package sessionstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shangsuru/passkey-demo/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSessionStore(t *testing.T, store SessionStore) {
+	ctx := context.Background()
+
+	t.Run("create and get", func(t *testing.T) {
+		assert.NoError(t, store.Create(ctx, "session", []byte("data"), time.Minute))
+
+		data, err := store.Get(ctx, "session")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("data"), data)
+	})
+
+	t.Run("missing session", func(t *testing.T) {
+		_, err := store.Get(ctx, "missing")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		assert.NoError(t, store.Create(ctx, "expired", []byte("data"), -time.Second))
+
+		_, err := store.Get(ctx, "expired")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("get and delete", func(t *testing.T) {
+		assert.NoError(t, store.Create(ctx, "single-use", []byte("data"), time.Minute))
+
+		data, err := store.GetAndDelete(ctx, "single-use")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("data"), data)
+
+		_, err = store.GetAndDelete(ctx, "single-use")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("get and delete expired session", func(t *testing.T) {
+		assert.NoError(t, store.Create(ctx, "expired-single-use", []byte("data"), -time.Second))
+
+		_, err := store.GetAndDelete(ctx, "expired-single-use")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.NoError(t, store.Create(ctx, "deleted", []byte("data"), time.Minute))
+		assert.NoError(t, store.Delete(ctx, "deleted"))
+
+		_, err := store.Get(ctx, "deleted")
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.NoError(t, store.Delete(ctx, "deleted"))
+	})
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore(time.Hour)
+	defer store.Close()
+
+	testSessionStore(t, store)
+
+	t.Run("janitor removes expired sessions", func(t *testing.T) {
+		assert.NoError(t, store.Create(context.Background(), "stale", []byte("data"), -time.Second))
+		store.deleteExpired()
+		assert.NotContains(t, store.entries, "stale")
+	})
+}
+
+func TestSQLStore(t *testing.T) {
+	database := db.GetTestDB()
+	defer database.Close()
+	store := NewSQLStore(database)
+
+	testSessionStore(t, store)
+
+	t.Run("removes expired sessions", func(t *testing.T) {
+		ctx := context.Background()
+		assert.NoError(t, store.Create(ctx, "stale", []byte("data"), -time.Second))
+		n, err := store.DeleteExpired(ctx)
+		assert.NoError(t, err)
+		assert.Positive(t, n)
+
+		count, err := database.NewSelect().Table("webauthn_sessions").Where("id = ?", "stale").Count(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, count)
+	})
+}
@@ -0,0 +1,54 @@
+package signedtoken
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type claims struct {
+	Email string `json:"email"`
+}
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner("secret", "verify-email")
+	now := time.Now()
+
+	token, err := signer.Sign(claims{Email: "alice@example.com"}, now.Add(time.Hour))
+	assert.NoError(t, err)
+
+	t.Run("valid", func(t *testing.T) {
+		var c claims
+		assert.NoError(t, signer.Verify(token, now, &c))
+		assert.Equal(t, "alice@example.com", c.Email)
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		var c claims
+		assert.ErrorIs(t, signer.Verify(token, now.Add(time.Hour), &c), ErrExpired)
+	})
+
+	t.Run("altered data", func(t *testing.T) {
+		other, err := signer.Sign(claims{Email: "mallory@example.com"}, now.Add(time.Hour))
+		assert.NoError(t, err)
+		data, _, _ := strings.Cut(other, ".")
+		_, signature, _ := strings.Cut(token, ".")
+
+		var c claims
+		assert.ErrorIs(t, signer.Verify(data+"."+signature, now, &c), ErrInvalid)
+	})
+
+	t.Run("other purpose or secret", func(t *testing.T) {
+		var c claims
+		assert.ErrorIs(t, NewSigner("secret", "reset-password").Verify(token, now, &c), ErrInvalid)
+		assert.ErrorIs(t, NewSigner("other", "verify-email").Verify(token, now, &c), ErrInvalid)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		var c claims
+		assert.ErrorIs(t, signer.Verify("", now, &c), ErrInvalid)
+		assert.ErrorIs(t, signer.Verify("abc.def", now, &c), ErrInvalid)
+	})
+}
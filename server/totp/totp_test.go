@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Secret of the SHA1 test vectors in RFC 6238, appendix B
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		// The RFC lists eight digits, of which the last six are the six digit code
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, code, "time %d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Counter(now)
+	code, _ := Code(rfcSecret, current)
+	previous, _ := Code(rfcSecret, current-1)
+	old, _ := Code(rfcSecret, current-2)
+
+	counter, ok := Validate(rfcSecret, code, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, current, counter)
+
+	counter, ok = Validate(rfcSecret, previous, now, 0)
+	assert.True(t, ok)
+	assert.Equal(t, current-1, counter)
+
+	_, ok = Validate(rfcSecret, old, now, 0)
+	assert.False(t, ok, "outside the skew")
+
+	_, ok = Validate(rfcSecret, code, now, current)
+	assert.False(t, ok, "already used")
+
+	_, ok = Validate(rfcSecret, previous, now, current-1)
+	assert.False(t, ok, "already used")
+
+	_, ok = Validate(rfcSecret, "050 471", now, 0)
+	assert.True(t, ok, "spaces are ignored")
+
+	_, ok = Validate(rfcSecret, "12345", now, 0)
+	assert.False(t, ok)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	other, _ := GenerateSecret()
+	assert.NotEqual(t, secret, other)
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Passkey Demo", "alice", "JBSWY3DPEHPK3PXP"))
+	assert.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Passkey Demo:alice", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "Passkey Demo", uri.Query().Get("issuer"))
+}
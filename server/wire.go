@@ -6,22 +6,59 @@ package main
 import (
 	"github.com/google/wire"
 	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/attestation"
+	"github.com/shangsuru/passkey-demo/config"
+	"github.com/shangsuru/passkey-demo/mailer"
+	"github.com/shangsuru/passkey-demo/mds"
+	"github.com/shangsuru/passkey-demo/middleware"
+	"github.com/shangsuru/passkey-demo/provider"
+	"github.com/shangsuru/passkey-demo/ratelimit"
 	"github.com/shangsuru/passkey-demo/repository"
+	"github.com/shangsuru/passkey-demo/sessionstore"
 
 	"github.com/shangsuru/passkey-demo/db"
 	"github.com/shangsuru/passkey-demo/handler"
 )
 
 // After updating this, run `go generate` to update wire_gen.go
-func NewServer() (*Server, error) {
+func NewServer(cfg *config.Config) (*Server, error) {
 	panic(wire.Build(
+		wire.FieldsOf(new(*config.Config), "Database", "Redis", "Session", "WebAuthn", "RateLimit", "Admin", "Auth", "CSRF", "Attestation", "Metadata", "Providers", "Mail"),
 		wire.Struct(new(Server), "*"),
 		echo.New,
+		wire.Struct(new(middleware.Auth), "*"),
+		wire.Struct(new(middleware.Reauth), "*"),
+		wire.Struct(new(middleware.RateLimit), "*"),
+		middleware.NewAdmin,
+		middleware.NewTopOrigin,
+		middleware.NewCSRF,
 		wire.Struct(new(handler.WebAuthnController), "*"),
 		wire.Struct(new(handler.PasswordController), "*"),
+		wire.Struct(new(handler.PasskeyController), "*"),
+		wire.Struct(new(handler.AccountController), "*"),
+		wire.Struct(new(handler.SessionController), "*"),
+		wire.Struct(new(handler.ReauthController), "*"),
+		wire.Struct(new(handler.TOTPController), "*"),
+		wire.Struct(new(handler.RecoveryController), "*"),
+		wire.Struct(new(handler.EmailController), "*"),
+		wire.Struct(new(handler.AdminController), "*"),
+		wire.Struct(new(handler.WellKnownController), "*"),
 		wire.Struct(new(repository.UserRepository), "*"),
+		wire.Struct(new(repository.SessionRepository), "*"),
 		db.GetDB,
+		sessionstore.NewSessionStore,
+		ratelimit.NewStore,
+		ratelimit.NewLimiter,
+		handler.NewCookies,
+		handler.NewSessionManager,
 		handler.NewWebAuthnSession,
 		handler.NewWebAuthnAPI,
+		handler.NewAntiEnumeration,
+		handler.NewEmailVerification,
+		handler.NewPasswordHasher,
+		attestation.NewPolicy,
+		mds.NewService,
+		provider.NewRegistry,
+		mailer.New,
 	))
 }
@@ -8,37 +8,186 @@ package main
 
 import (
 	"github.com/labstack/echo/v4"
+	"github.com/shangsuru/passkey-demo/attestation"
+	"github.com/shangsuru/passkey-demo/config"
 	"github.com/shangsuru/passkey-demo/db"
 	"github.com/shangsuru/passkey-demo/handler"
+	"github.com/shangsuru/passkey-demo/mailer"
+	"github.com/shangsuru/passkey-demo/mds"
+	"github.com/shangsuru/passkey-demo/middleware"
+	"github.com/shangsuru/passkey-demo/provider"
+	"github.com/shangsuru/passkey-demo/ratelimit"
 	"github.com/shangsuru/passkey-demo/repository"
+	"github.com/shangsuru/passkey-demo/sessionstore"
 )
 
 // Injectors from wire.go:
 
 // After updating this, run `go generate` to update wire_gen.go
-func NewServer() (*Server, error) {
+func NewServer(cfg *config.Config) (*Server, error) {
 	echoEcho := echo.New()
-	bunDB := db.GetDB()
+	sessionConfig := cfg.Session
+	databaseConfig := cfg.Database
+	bunDB := db.GetDB(databaseConfig)
+	sessionRepository := repository.SessionRepository{
+		DB: bunDB,
+	}
+	cookies := handler.NewCookies(sessionConfig)
+	sessionManager := handler.NewSessionManager(sessionConfig, sessionRepository, cookies)
+	auth := middleware.Auth{
+		SessionManager: sessionManager,
+	}
+	reauth := middleware.Reauth{
+		SessionManager: sessionManager,
+	}
+	rateLimitConfig := cfg.RateLimit
+	redisConfig := cfg.Redis
+	store, err := ratelimit.NewStore(rateLimitConfig, redisConfig)
+	if err != nil {
+		return nil, err
+	}
+	limiter := ratelimit.NewLimiter(store, rateLimitConfig)
+	rateLimit := middleware.RateLimit{
+		Limiter: limiter,
+	}
+	adminConfig := cfg.Admin
+	admin := middleware.NewAdmin(adminConfig)
+	webAuthnConfig := cfg.WebAuthn
+	topOrigin := middleware.NewTopOrigin(webAuthnConfig)
+	csrfConfig := cfg.CSRF
+	csrf := middleware.NewCSRF(webAuthnConfig, csrfConfig)
 	userRepository := repository.UserRepository{
 		DB: bunDB,
 	}
-	webAuthn, err := handler.NewWebAuthnAPI()
+	webAuthn, err := handler.NewWebAuthnAPI(webAuthnConfig)
+	if err != nil {
+		return nil, err
+	}
+	sessionStore, err := sessionstore.NewSessionStore(sessionConfig, redisConfig, bunDB)
+	if err != nil {
+		return nil, err
+	}
+	webAuthnSession := handler.NewWebAuthnSession(sessionStore, cookies)
+	authConfig := cfg.Auth
+	antiEnumeration, err := handler.NewAntiEnumeration(authConfig, sessionConfig)
+	if err != nil {
+		return nil, err
+	}
+	attestationConfig := cfg.Attestation
+	metadataConfig := cfg.Metadata
+	service, err := mds.NewService(metadataConfig)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := attestation.NewPolicy(attestationConfig, service)
+	if err != nil {
+		return nil, err
+	}
+	providersConfig := cfg.Providers
+	registry, err := provider.NewRegistry(providersConfig)
 	if err != nil {
 		return nil, err
 	}
-	webAuthnSession := handler.NewWebAuthnSession()
 	webAuthnController := handler.WebAuthnController{
+		UserRepository:    userRepository,
+		WebAuthnAPI:       webAuthn,
+		WebAuthnSession:   webAuthnSession,
+		SessionManager:    sessionManager,
+		Limiter:           limiter,
+		AntiEnumeration:   antiEnumeration,
+		AttestationPolicy: policy,
+		Providers:         registry,
+	}
+	passwordHasher := handler.NewPasswordHasher(authConfig)
+	mailConfig := cfg.Mail
+	mailerMailer, err := mailer.New(mailConfig)
+	if err != nil {
+		return nil, err
+	}
+	passwordController := handler.PasswordController{
+		UserRepository:    userRepository,
+		SessionRepository: sessionRepository,
+		SessionManager:    sessionManager,
+		Limiter:           limiter,
+		AntiEnumeration:   antiEnumeration,
+		PasswordHasher:    passwordHasher,
+		Mailer:            mailerMailer,
+		MailConfig:        mailConfig,
+		WebAuthnConfig:    webAuthnConfig,
+	}
+	passkeyController := handler.PasskeyController{
+		UserRepository: userRepository,
+		SessionManager: sessionManager,
+		Metadata:       service,
+		Providers:      registry,
+	}
+	accountController := handler.AccountController{
 		UserRepository:  userRepository,
 		WebAuthnAPI:     webAuthn,
 		WebAuthnSession: webAuthnSession,
+		SessionManager:  sessionManager,
+		Limiter:         limiter,
 	}
-	passwordController := handler.PasswordController{
+	sessionController := handler.SessionController{
+		SessionRepository: sessionRepository,
+		SessionManager:    sessionManager,
+	}
+	reauthController := handler.ReauthController{
+		UserRepository:  userRepository,
+		WebAuthnAPI:     webAuthn,
+		WebAuthnSession: webAuthnSession,
+		SessionManager:  sessionManager,
+		Limiter:         limiter,
+	}
+	totpController := handler.TOTPController{
 		UserRepository: userRepository,
+		SessionManager: sessionManager,
+		Limiter:        limiter,
+		WebAuthnConfig: webAuthnConfig,
+	}
+	recoveryController := handler.RecoveryController{
+		UserRepository: userRepository,
+		SessionManager: sessionManager,
+		Limiter:        limiter,
+	}
+	emailVerification := handler.NewEmailVerification(mailConfig, webAuthnConfig, sessionConfig)
+	emailController := handler.EmailController{
+		UserRepository:    userRepository,
+		SessionManager:    sessionManager,
+		Mailer:            mailerMailer,
+		EmailVerification: emailVerification,
+		WebAuthnConfig:    webAuthnConfig,
+	}
+	adminController := handler.AdminController{
+		Limiter: limiter,
+	}
+	wellKnownController := handler.WellKnownController{
+		WebAuthnConfig: webAuthnConfig,
 	}
 	server := &Server{
-		router:             echoEcho,
-		webAuthnController: webAuthnController,
-		passwordController: passwordController,
+		config:              cfg,
+		router:              echoEcho,
+		auth:                auth,
+		reauth:              reauth,
+		rateLimit:           rateLimit,
+		admin:               admin,
+		topOrigin:           topOrigin,
+		csrf:                csrf,
+		webAuthnController:  webAuthnController,
+		passwordController:  passwordController,
+		passkeyController:   passkeyController,
+		accountController:   accountController,
+		sessionController:   sessionController,
+		reauthController:    reauthController,
+		totpController:      totpController,
+		recoveryController:  recoveryController,
+		emailController:     emailController,
+		adminController:     adminController,
+		wellKnownController: wellKnownController,
+		userRepository:      userRepository,
+		sessionRepository:   sessionRepository,
+		sessionStore:        sessionStore,
+		metadata:            service,
 	}
 	return server, nil
 }